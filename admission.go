@@ -0,0 +1,133 @@
+package goreaction
+
+import (
+	"golang.org/x/sys/unix"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// RejectReason 连接被拒绝的原因
+type RejectReason int
+
+const (
+	RejectMaxConnections RejectReason = iota + 1
+	RejectMaxConnectionsPerIP
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectMaxConnections:
+		return "max connections"
+	case RejectMaxConnectionsPerIP:
+		return "max connections per ip"
+	default:
+		return "unknown"
+	}
+}
+
+// admission 全局及单个 IP 的连接数限制
+type admission struct {
+	maxConns int64
+	maxPerIP int
+
+	total atomic.Int64
+	mu    sync.Mutex
+	perIP map[string]int
+}
+
+func newAdmission(maxConns, maxPerIP int) *admission {
+	return &admission{
+		maxConns: int64(maxConns),
+		maxPerIP: maxPerIP,
+		perIP:    make(map[string]int),
+	}
+}
+
+// acquire 占用一个连接名额，ip 为空时不做单 IP 限制
+func (a *admission) acquire(ip string) (RejectReason, bool) {
+	if n := a.total.Add(1); a.maxConns > 0 && n > a.maxConns {
+		a.total.Add(-1)
+		return RejectMaxConnections, false
+	}
+	if !a.acquireIP(ip) {
+		a.total.Add(-1)
+		return RejectMaxConnectionsPerIP, false
+	}
+	return 0, true
+}
+
+// acquireIP 只占用 ip 的名额，用于 PROXY 头解析出真实来源之后
+func (a *admission) acquireIP(ip string) bool {
+	if a.maxPerIP <= 0 || ip == "" {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.perIP[ip] >= a.maxPerIP {
+		return false
+	}
+	a.perIP[ip]++
+	return true
+}
+
+func (a *admission) release(ip string) {
+	a.total.Add(-1)
+	a.releaseIP(ip)
+}
+
+func (a *admission) releaseIP(ip string) {
+	if a.maxPerIP <= 0 || ip == "" {
+		return
+	}
+	a.mu.Lock()
+	if a.perIP[ip] <= 1 {
+		delete(a.perIP, ip)
+	} else {
+		a.perIP[ip]--
+	}
+	a.mu.Unlock()
+}
+
+func admissionKey(sa unix.Sockaddr) string {
+	if ip := sockaddrIP(sa); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// admitProxied PROXY 头解析后按真实来源检查单 IP 连接数，未通过时关闭连接
+func (s *Server) admitProxied(c *Connection) (ip string, ok bool) {
+	if c.proxyHeader.Local {
+		return "", true
+	}
+	ip, _, err := net.SplitHostPort(c.peerAddr)
+	if err != nil {
+		// unix 等没有 IP 的来源不做单 IP 限制
+		return "", true
+	}
+	if s.admission.acquireIP(ip) {
+		return ip, true
+	}
+	if len(s.opts.RejectPayload) > 0 {
+		_, _ = unix.Write(c.fd, s.opts.RejectPayload)
+	}
+	c.closeWith(CloseLocal, nil)
+	if s.opts.RejectHandler != nil {
+		s.opts.RejectHandler(c.peerAddr, RejectMaxConnectionsPerIP)
+	}
+	return "", false
+}
+
+// reject 拒绝新连接，设置了 RejectPayload 时先尽力写出再关闭
+func (s *Server) reject(fd int, sa unix.Sockaddr, reason RejectReason) {
+	if len(s.opts.RejectPayload) > 0 {
+		_, _ = unix.Write(fd, s.opts.RejectPayload)
+		_ = unix.Shutdown(fd, unix.SHUT_WR)
+	}
+	_ = unix.Close(fd)
+
+	if s.opts.RejectHandler != nil {
+		s.opts.RejectHandler(sockAddrToString(sa), reason)
+	}
+}
@@ -0,0 +1,120 @@
+package goreaction
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAdmission(t *testing.T) {
+	a := newAdmission(3, 2)
+
+	for i := 0; i < 2; i++ {
+		_, ok := a.acquire("10.0.0.1")
+		assert.True(t, ok)
+	}
+	reason, ok := a.acquire("10.0.0.1")
+	assert.False(t, ok)
+	assert.Equal(t, RejectMaxConnectionsPerIP, reason)
+
+	_, ok = a.acquire("10.0.0.2")
+	assert.True(t, ok)
+	reason, ok = a.acquire("10.0.0.3")
+	assert.False(t, ok)
+	assert.Equal(t, RejectMaxConnections, reason)
+
+	a.release("10.0.0.1")
+	_, ok = a.acquire("10.0.0.1")
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), a.total.Load())
+}
+
+func TestServer_MaxConnections(t *testing.T) {
+	handler := new(serverTest)
+	rejects := make(chan RejectReason, 4)
+	s, err := NewServer(handler,
+		Address("127.0.0.1:12360"),
+		MaxConnections(2),
+		RejectPayload([]byte("server full\n")),
+		OnReject(func(addr string, reason RejectReason) {
+			rejects <- reason
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conns := make([]net.Conn, 2)
+	for i := range conns {
+		conns[i], err = net.Dial("tcp", "127.0.0.1:12360")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conns[i].Close()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12360")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(conn)
+	_ = conn.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, "server full\n", string(data))
+	assert.Equal(t, RejectMaxConnections, <-rejects)
+
+	// 关闭一个连接后可以重新接入
+	_ = conns[0].Close()
+	time.Sleep(50 * time.Millisecond)
+	conn, err = net.Dial("tcp", "127.0.0.1:12360")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestServer_MaxConnectionsPerIP(t *testing.T) {
+	handler := new(serverTest)
+	rejects := make(chan RejectReason, 4)
+	s, err := NewServer(handler,
+		Address("127.0.0.1:12361"),
+		MaxConnectionsPerIP(1),
+		OnReject(func(addr string, reason RejectReason) {
+			rejects <- reason
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	first, err := net.Dial("tcp", "127.0.0.1:12361")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	second, err := net.Dial("tcp", "127.0.0.1:12361")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	n, err := second.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, RejectMaxConnectionsPerIP, <-rejects)
+}
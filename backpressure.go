@@ -0,0 +1,95 @@
+package goreaction
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrWouldBlock       = errors.New("write buffer above high water mark")
+	ErrWriteBufferFull  = errors.New("write buffer exceeds hard cap")
+	errWriteBufferLimit = errors.New("write buffer low water mark must be less than high water mark")
+)
+
+// WriteBufferHandler Handler 可选实现，待写数据达到高水位、回落到低水位时在 loop 中回调
+type WriteBufferHandler interface {
+	OnWriteBufferHigh(c *Connection)
+	OnWriteBufferLow(c *Connection)
+}
+
+// pendingWrite 待写数据量，包括 Send 排队中的数据和 outBuf
+func (c *Connection) pendingWrite() int64 {
+	return c.outBufLen.Load() + c.sendQueued.Load()
+}
+
+func (c *Connection) aboveHighWater() bool {
+	return c.writeHigh > 0 && c.pendingWrite() >= c.writeHigh
+}
+
+// checkWriteBuffer 更新 outBuf 长度并检查水位，超过硬上限时关闭连接，只能在 loop 中调用
+func (c *Connection) checkWriteBuffer() (closed bool) {
+	c.outBufLen.Store(int64(c.outBuf.Length()))
+	n := c.pendingWrite()
+
+	if c.writeCap > 0 && n > c.writeCap {
+		c.reportError(OpWrite, ErrWriteBufferFull)
+		c.closeWith(CloseBufferFull, ErrWriteBufferFull)
+		return true
+	}
+	if c.writeHigh <= 0 {
+		return
+	}
+	if !c.aboveHigh && n >= c.writeHigh {
+		c.aboveHigh = true
+		if c.writeHook != nil {
+			c.writeHook.OnWriteBufferHigh(c)
+		}
+	} else if c.aboveHigh && n <= c.writeLow {
+		c.aboveHigh = false
+		if c.writeHook != nil {
+			c.writeHook.OnWriteBufferLow(c)
+		}
+	}
+	if n < c.writeHigh {
+		c.wakeWriters()
+	}
+	return !c.connected.Load()
+}
+
+// wakeWriters 唤醒阻塞在 SendContext 中的 goroutine
+func (c *Connection) wakeWriters() {
+	c.writeMu.Lock()
+	if c.writable != nil {
+		close(c.writable)
+		c.writable = nil
+	}
+	c.writeMu.Unlock()
+}
+
+// SendContext 与 Send 相同，待写数据达到高水位时阻塞到回落至高水位以下、连接关闭或 ctx 结束。
+// 不能在 loop 中（如 OnMessage）调用
+func (c *Connection) SendContext(ctx context.Context, data []byte) error {
+	for {
+		err := c.Send(data)
+		if err != ErrWouldBlock {
+			return err
+		}
+
+		c.writeMu.Lock()
+		if c.writable == nil {
+			c.writable = make(chan struct{})
+		}
+		ch := c.writable
+		c.writeMu.Unlock()
+		// 注册之后再检查一次，避免错过 loop 的唤醒
+		if !c.aboveHighWater() || !c.connected.Load() {
+			continue
+		}
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package goreaction
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type backpressureExample struct {
+	serverTest
+	conns chan *Connection
+	high  chan struct{}
+	low   chan struct{}
+}
+
+func (s *backpressureExample) OnConnect(c *Connection) {
+	s.conns <- c
+}
+
+func (s *backpressureExample) OnWriteBufferHigh(c *Connection) {
+	s.high <- struct{}{}
+}
+
+func (s *backpressureExample) OnWriteBufferLow(c *Connection) {
+	s.low <- struct{}{}
+}
+
+func TestWriteBufferWaterMark(t *testing.T) {
+	handler := &backpressureExample{
+		conns: make(chan *Connection, 1),
+		high:  make(chan struct{}, 1),
+		low:   make(chan struct{}, 1),
+	}
+	s, err := NewServer(handler, Address("127.0.0.1:12368"), NumLoops(1), WriteBufferWaterMark(64*1024, 256*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12368")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := <-handler.conns
+
+	// 客户端不读取，直到待写数据达到高水位
+	chunk := make([]byte, 64*1024)
+	blocked := false
+	for i := 0; i < 1024 && !blocked; i++ {
+		switch err := c.Send(chunk); err {
+		case nil:
+			time.Sleep(time.Millisecond)
+		case ErrWouldBlock:
+			blocked = true
+		default:
+			t.Fatal(err)
+		}
+	}
+	if !blocked {
+		t.Fatal("Send should return ErrWouldBlock")
+	}
+	select {
+	case <-handler.high:
+	case <-time.After(time.Second):
+		t.Fatal("OnWriteBufferHigh should be called")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	if err := c.SendContext(ctx, chunk); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SendContext should time out, but %v", err)
+	}
+	cancel()
+
+	sent := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		sent <- c.SendContext(ctx, chunk)
+	}()
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	select {
+	case <-handler.low:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnWriteBufferLow should be called")
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteBufferHardCap(t *testing.T) {
+	handler := &backpressureExample{conns: make(chan *Connection, 1)}
+	errs := make(chan error, 1)
+	s, err := NewServer(handler, Address("127.0.0.1:12369"), NumLoops(1), WriteBufferHardCap(1024*1024),
+		OnError(func(err *OpError) {
+			select {
+			case errs <- err.Err:
+			default:
+			}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12369")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := <-handler.conns
+
+	chunk := make([]byte, 64*1024)
+	for i := 0; i < 1024 && c.Connected(); i++ {
+		_ = c.Send(chunk)
+		time.Sleep(time.Millisecond)
+	}
+	if c.Connected() {
+		t.Fatal("connection should be closed after exceeding hard cap")
+	}
+	if err := <-errs; !errors.Is(err, ErrWriteBufferFull) {
+		t.Fatalf("error should be ErrWriteBufferFull, but %v", err)
+	}
+}
+
+func TestWriteBufferWaterMarkInvalid(t *testing.T) {
+	if _, err := NewServer(new(serverTest), WriteBufferWaterMark(1024, 1024)); err == nil {
+		t.Fatal("low water mark equal to high should fail")
+	}
+}
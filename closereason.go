@@ -0,0 +1,98 @@
+package goreaction
+
+// CloseReason 连接关闭的原因
+type CloseReason int
+
+const (
+	CloseUnknown CloseReason = iota
+	// ClosePeerEOF 对端关闭连接
+	ClosePeerEOF
+	// CloseReadError 读 socket 出错，如 ECONNRESET
+	CloseReadError
+	// CloseWriteError 写 socket 或修改关注事件出错
+	CloseWriteError
+	// ClosePollError poller 报告 EventErr/HUP
+	ClosePollError
+	// CloseIdleTimeout 连接空闲，见 IdleTime、IdleState
+	CloseIdleTimeout
+	// CloseLocal 调用了 Close
+	CloseLocal
+	// CloseServerStop Server Shutdown 时关闭
+	CloseServerStop
+	// CloseProtocolError Protocol、TLS 或 PROXY 头处理出错，包括 CloseWithError
+	CloseProtocolError
+	// CloseBufferFull 读缓冲区超过 MaxReadBufferSize 或写缓冲区超过 WriteBufferHardCap
+	CloseBufferFull
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case ClosePeerEOF:
+		return "peer eof"
+	case CloseReadError:
+		return "read error"
+	case CloseWriteError:
+		return "write error"
+	case ClosePollError:
+		return "poll error"
+	case CloseIdleTimeout:
+		return "idle timeout"
+	case CloseLocal:
+		return "closed locally"
+	case CloseServerStop:
+		return "server stop"
+	case CloseProtocolError:
+		return "protocol error"
+	case CloseBufferFull:
+		return "buffer full"
+	}
+	return "unknown"
+}
+
+type closeInfo struct {
+	reason CloseReason
+	err    error
+}
+
+// CloseReason 连接关闭的原因及底层错误，连接未关闭时返回 CloseUnknown。
+// OnClose 中可以读取
+func (c *Connection) CloseReason() (CloseReason, error) {
+	if info := c.closeInfo.Load(); info != nil {
+		return info.reason, info.err
+	}
+	return CloseUnknown, nil
+}
+
+// closeWith 记录原因并关闭连接，只能在 loop 中调用，已关闭时不覆盖原因
+func (c *Connection) closeWith(reason CloseReason, err error) {
+	if !c.connected.Load() {
+		return
+	}
+	c.closeInfo.Store(&closeInfo{reason: reason, err: err})
+	c.handleClose(c.fd)
+}
+
+// closeRead read 返回 0 或出错时关闭，err 为 nil 表示对端关闭，HalfClose 模式下只关闭读端
+func (c *Connection) closeRead(err error) (closed bool) {
+	if err != nil {
+		c.closeWith(CloseReadError, err)
+		return true
+	}
+	if c.halfClose && c.tls == nil {
+		return c.handleReadClosed()
+	}
+	c.closeWith(ClosePeerEOF, nil)
+	return true
+}
+
+// closeAsync 在 loop 中以 reason 关闭连接
+func (c *Connection) closeAsync(reason CloseReason, err error) error {
+	if !c.connected.Load() {
+		return ErrConnectionClosed
+	}
+
+	c.loop.QueueInLoop(func() {
+		c.closeWith(reason, err)
+	})
+	return nil
+}
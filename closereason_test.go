@@ -0,0 +1,111 @@
+package goreaction
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"net"
+	"testing"
+	"time"
+)
+
+type closeReasonExample struct {
+	serverTest
+	conns   chan *Connection
+	reasons chan CloseReason
+	errs    chan error
+}
+
+func (s *closeReasonExample) OnConnect(c *Connection) {
+	s.conns <- c
+}
+
+func (s *closeReasonExample) OnClose(c *Connection) {
+	reason, err := c.CloseReason()
+	s.reasons <- reason
+	s.errs <- err
+}
+
+func TestConnCloseReason(t *testing.T) {
+	handler := &closeReasonExample{
+		conns:   make(chan *Connection, 1),
+		reasons: make(chan CloseReason, 1),
+		errs:    make(chan error, 1),
+	}
+	s, err := NewServer(handler, Address("127.0.0.1:12375"), NumLoops(1), IdleTime(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	cases := []struct {
+		name   string
+		close  func(conn *net.TCPConn, c *Connection)
+		reason CloseReason
+		err    error
+	}{
+		{"peer eof", func(conn *net.TCPConn, c *Connection) { _ = conn.Close() }, ClosePeerEOF, nil},
+		{"reset", func(conn *net.TCPConn, c *Connection) {
+			_ = conn.SetLinger(0)
+			_ = conn.Close()
+		}, CloseReadError, unix.ECONNRESET},
+		{"local", func(conn *net.TCPConn, c *Connection) { _ = c.Close() }, CloseLocal, nil},
+		{"idle", func(conn *net.TCPConn, c *Connection) {}, CloseIdleTimeout, nil},
+	}
+	for _, tc := range cases {
+		conn, err := net.Dial("tcp", "127.0.0.1:12375")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := <-handler.conns
+		if reason, _ := c.CloseReason(); reason != CloseUnknown {
+			t.Fatalf("%s: open connection reason should be unknown, but %v", tc.name, reason)
+		}
+		tc.close(conn.(*net.TCPConn), c)
+
+		select {
+		case reason := <-handler.reasons:
+			if reason != tc.reason {
+				t.Fatalf("%s: reason should be %v, but %v", tc.name, tc.reason, reason)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("%s: OnClose timeout", tc.name)
+		}
+		if err := <-handler.errs; !errors.Is(err, tc.err) {
+			t.Fatalf("%s: error should be %v, but %v", tc.name, tc.err, err)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestServerStopCloseReason(t *testing.T) {
+	handler := &closeReasonExample{
+		conns:   make(chan *Connection, 1),
+		reasons: make(chan CloseReason, 1),
+		errs:    make(chan error, 1),
+	}
+	s, err := NewServer(handler, Address("127.0.0.1:12390"), NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12390")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-handler.conns
+
+	s.Stop()
+	select {
+	case reason := <-handler.reasons:
+		if reason != CloseServerStop {
+			t.Fatalf("reason should be %v, but %v", CloseServerStop, reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnClose timeout")
+	}
+}
@@ -0,0 +1,154 @@
+package goreaction
+
+import (
+	"goreaction/poller"
+	"goreaction/ringbuffer"
+)
+
+// completionState loop 的 poller 支持 Completion（io_uring）时连接以 recv、sendmsg 请求收发，
+// 请求与同一轮的其他请求合并为一次系统调用提交。只在 loop 中访问
+type completionState struct {
+	io       poller.Completion
+	recvDone func(data []byte, err error)
+	sendDone func(n int, err error)
+	started  bool
+	recving  bool
+	sending  bool
+	// stash 暂停读取前已提交的 recv 读到的数据，恢复读取后先处理
+	stash []byte
+	// release 连接关闭时 sendmsg 尚未完成，完成后再归还 outBuf
+	release bool
+}
+
+func (c *Connection) enableCompletion(io poller.Completion) {
+	c.completion = &completionState{
+		io:       io,
+		recvDone: c.handleRecv,
+		sendDone: c.handleSend,
+	}
+}
+
+// startCompletion 连接注册后开始读取，并写出注册前排队的数据
+func (c *Connection) startCompletion() {
+	c.completion.started = true
+	if c.flushCompletion() {
+		return
+	}
+	c.recvCompletion()
+}
+
+// recvCompletion 没有进行中的 recv 时提交，与水平触发时一样先写完 outBuf 再读
+func (c *Connection) recvCompletion() (closed bool) {
+	cs := c.completion
+	for {
+		if !c.connected.Load() {
+			return true
+		}
+		if !cs.started || cs.recving || c.readPaused.Load() || c.readClosed.Load() || c.writePending() {
+			return false
+		}
+		if len(cs.stash) > 0 {
+			data := cs.stash
+			cs.stash = nil
+			if c.handleRecvData(data) {
+				return true
+			}
+			continue
+		}
+		if err := cs.io.Recv(c.fd, cs.recvDone); err != nil {
+			c.reportError(OpRead, err)
+			c.closeWith(CloseReadError, err)
+			return true
+		}
+		cs.recving = true
+		return false
+	}
+}
+
+func (c *Connection) handleRecv(data []byte, err error) {
+	cs := c.completion
+	cs.recving = false
+	if !c.connected.Load() {
+		return
+	}
+	if len(data) == 0 {
+		c.closeRead(err)
+		return
+	}
+	if c.readPaused.Load() {
+		cs.stash = append(cs.stash, data...)
+		return
+	}
+	if c.handleRecvData(data) {
+		return
+	}
+	c.recvCompletion()
+}
+
+// handleRecvData 与 handleRead 相同地处理读到的数据，data 剩余容量用作回复的缓冲区
+func (c *Connection) handleRecvData(data []byte) (closed bool) {
+	if c.handleData(data, len(data)) {
+		return true
+	}
+	if c.inBuf.IsEmpty() {
+		c.inBuf.Reset()
+	}
+	c.inBufLen.Swap(int64(c.inBuf.Length()))
+	return c.checkWriteBuffer()
+}
+
+// writeCompletion 数据追加到 outBuf，没有进行中的 sendmsg 时提交
+func (c *Connection) writeCompletion(bufs ...[]byte) (closed bool) {
+	for _, b := range bufs {
+		_, _ = c.outBuf.Write(b)
+	}
+	return c.flushCompletion()
+}
+
+// flushCompletion 以一次 sendmsg 提交 outBuf 中的全部数据，完成前 outBuf 中的这部分数据不会被修改
+func (c *Connection) flushCompletion() (closed bool) {
+	cs := c.completion
+	if !cs.started || cs.sending || c.outBuf.IsEmpty() {
+		return
+	}
+	ft, ed := c.outBuf.PeekAll()
+	if err := cs.io.Send(c.fd, [][]byte{ft, ed}, cs.sendDone); err != nil {
+		c.reportError(OpWrite, err)
+		c.closeWith(CloseWriteError, err)
+		return true
+	}
+	cs.sending = true
+	return
+}
+
+func (c *Connection) handleSend(n int, err error) {
+	cs := c.completion
+	cs.sending = false
+	if cs.release {
+		ringbuffer.PutInPool(c.outBuf)
+		return
+	}
+	if !c.connected.Load() {
+		return
+	}
+	if err != nil {
+		c.closeWith(CloseWriteError, err)
+		return
+	}
+
+	if n > 0 {
+		c.touchWrite()
+	}
+	c.outBuf.Retrieve(n)
+	if !c.outBuf.IsEmpty() {
+		if c.flushCompletion() {
+			return
+		}
+	} else {
+		c.outBuf.Reset()
+		if c.outBufDrained() || c.recvCompletion() {
+			return
+		}
+	}
+	c.checkWriteBuffer()
+}
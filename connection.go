@@ -3,16 +3,14 @@ package goreaction
 import (
 	"errors"
 	"fmt"
-	"github.com/RussellLuo/timingwheel"
 	"golang.org/x/sys/unix"
 	"goreaction/eventloop"
 	"goreaction/poller"
 	"goreaction/ringbuffer"
-	"log"
 	"net"
 	"strconv"
+	"sync"
 	"sync/atomic"
-	"time"
 )
 
 type Callback interface {
@@ -21,74 +19,146 @@ type Callback interface {
 }
 
 type Connection struct {
-	outBufLen  atomic.Int64
-	inBufLen   atomic.Int64
-	activeTime atomic.Int64
-	fd         int
-	connected  atomic.Bool
-	buf        *ringbuffer.RingBuffer
-	outBuf     *ringbuffer.RingBuffer
-	inBuf      *ringbuffer.RingBuffer
-	callback   Callback
-	loop       *eventloop.EventLoop
-	peerAddr   string
-	ctx        interface{}
+	outBufLen atomic.Int64
+	inBufLen  atomic.Int64
+	lastRead  atomic.Int64
+	lastWrite atomic.Int64
+	fd        int
+	connected atomic.Bool
+	buf       *ringbuffer.RingBuffer
+	outBuf    *ringbuffer.RingBuffer
+	inBuf     *ringbuffer.RingBuffer
+	callback  Callback
+	loop      *eventloop.EventLoop
+	peerAddr  string
+	localAddr string
+	peerCred  *unix.Ucred
+	ctx       interface{}
 	KeyValueContext
 
-	idleTime    time.Duration
-	timingWheel *timingwheel.TimingWheel
-	timer       atomic.Value
-	protocol    Protocol
+	idle         *idleState
+	idleHook     IdleHandler
+	timer        atomic.Pointer[eventloop.Timer]
+	protocol     Protocol
+	draining     bool
+	drainReason  CloseReason
+	shutdownHook ShutdownHandler
+	onError      ErrorHandler
+	tls          *tlsState
+	proxy        *proxyState
+	proxyHeader  *ProxyHeader
+	opened       bool
+	onRelease    func()
+	closeInfo    atomic.Pointer[closeInfo]
+
+	edgeTriggered bool
+	readPending   bool
+	budget        int
+	completion    *completionState
+
+	readPaused atomic.Bool
+	maxReadBuf int
+
+	halfClose      bool
+	readClosed     atomic.Bool
+	readClosedHook ReadClosedHandler
+	shutWr         atomic.Bool
+	shutWrPending  bool
+	shutWrDone     bool
+
+	sendQueued atomic.Int64
+	writeHigh  int64
+	writeLow   int64
+	writeCap   int64
+	aboveHigh  bool
+	writeHook  WriteBufferHandler
+	writeMu    sync.Mutex
+	writable   chan struct{}
+	pendMu     sync.Mutex
+	pending    []pendingSend
+	sendFiles  []*fileSend
+	fileHook   SendFileHandler
+
+	pool   *workerPool
+	worker chan workerTask
+	// inflight 已分发到 worker 但 OnMessage 尚未返回的消息数
+	inflight    atomic.Int32
+	closeNotify bool
+	relay       *Relay
+
+	timerMu      sync.Mutex
+	timers       map[*eventloop.Timer]struct{}
+	timersClosed bool
 }
 
-var ErrConnectionClosed = errors.New("connection closed")
+var (
+	ErrConnectionClosed = errors.New("connection closed")
+	ErrReadBufferFull   = errors.New("read buffer exceeds limit")
+)
 
 func NewConnection(fd int,
 	loop *eventloop.EventLoop,
 	sa unix.Sockaddr,
-	protocol Protocol,
-	tw *timingwheel.TimingWheel,
-	idleTime time.Duration,
+	opts *Options,
 	back Callback) *Connection {
 	conn := &Connection{
-		fd:          fd,
-		peerAddr:    sockAddrToString(sa),
-		outBuf:      ringbuffer.GetFromPool(),
-		inBuf:       ringbuffer.GetFromPool(),
-		callback:    back,
-		loop:        loop,
-		idleTime:    idleTime,
-		timingWheel: tw,
-		protocol:    protocol,
-		buf:         ringbuffer.New(0),
+		fd:        fd,
+		peerAddr:  sockAddrToString(sa),
+		outBuf:    ringbuffer.GetFromPool(),
+		inBuf:     ringbuffer.GetFromPool(),
+		callback:  back,
+		loop:      loop,
+		protocol:  opts.Protocol,
+		buf:       ringbuffer.New(0),
+		onError:   opts.ErrorHandler,
+		budget:    opts.EdgeTriggeredBudget,
+		writeHigh: int64(opts.WriteBufferHighWater),
+		writeLow:  int64(opts.WriteBufferLowWater),
+		writeCap:  int64(opts.WriteBufferHardCap),
+		halfClose: opts.HalfClose,
+	}
+	conn.setHooks(handlerOf(back))
+	if io := loop.Completion(); io != nil {
+		conn.enableCompletion(io)
+	}
+	conn.idle = newIdleState(opts)
+	conn.maxReadBuf = opts.MaxReadBufferSize
+	if l, ok := opts.Protocol.(ReadBufferLimiter); ok && l.MaxReadBufferSize() > 0 {
+		conn.maxReadBuf = l.MaxReadBufferSize()
 	}
 	conn.connected.Store(true)
+	if lsa, err := unix.Getsockname(fd); err == nil {
+		conn.localAddr = sockAddrToString(lsa)
+	}
+	if _, ok := sa.(*unix.SockaddrUnix); ok {
+		conn.peerCred, _ = unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}
 
-	if conn.idleTime > 0 {
-		_ = conn.activeTime.Swap(time.Now().Unix())
-		timer := conn.timingWheel.AfterFunc(conn.idleTime, conn.closeTimeoutConn())
-		conn.timer.Store(timer)
+	if conn.idle != nil {
+		conn.startIdle()
 	}
 	return conn
 }
 
-func (c *Connection) UserBuffer() *[]byte {
-	return c.loop.UserBuf
+// handlerOf 实现可选接口的 handler，Dial 的连接为传给 Dial 的 handler
+func handlerOf(back Callback) interface{} {
+	if cb, ok := back.(connectorCallback); ok {
+		return cb.handler
+	}
+	return back
 }
 
-func (c *Connection) closeTimeoutConn() func() {
-	return func() {
-		now := time.Now()
-		intervals := now.Sub(time.Unix(c.activeTime.Load(), 0))
-		if intervals >= c.idleTime {
-			_ = c.Close()
-		} else {
-			if c.connected.Load() {
-				timer := c.timingWheel.AfterFunc(c.idleTime-intervals, c.closeTimeoutConn())
-				c.timer.Store(timer)
-			}
-		}
-	}
+// setHooks 解析 handler 实现的可选接口，新增的可选接口都在这里解析
+func (c *Connection) setHooks(h interface{}) {
+	c.writeHook, _ = h.(WriteBufferHandler)
+	c.readClosedHook, _ = h.(ReadClosedHandler)
+	c.fileHook, _ = h.(SendFileHandler)
+	c.shutdownHook, _ = h.(ShutdownHandler)
+	c.idleHook, _ = h.(IdleHandler)
+}
+
+func (c *Connection) UserBuffer() *[]byte {
+	return c.loop.UserBuf
 }
 
 func (c *Connection) Context() interface{} {
@@ -103,36 +173,148 @@ func (c *Connection) PeerAddr() string {
 	return c.peerAddr
 }
 
+func (c *Connection) LocalAddr() string {
+	return c.localAddr
+}
+
+// PeerCred unix socket 对端进程的凭证，非 unix 连接返回 nil
+func (c *Connection) PeerCred() *unix.Ucred {
+	return c.peerCred
+}
+
 func (c *Connection) Connected() bool {
 	return c.connected.Load()
 }
 
+// Send 异步发送 data，设置了高水位且待写数据达到高水位时返回 ErrWouldBlock
 func (c *Connection) Send(data []byte) error {
 	if !c.connected.Load() {
 		return ErrConnectionClosed
 	}
+	if c.shutWr.Load() {
+		return ErrWriteShutdown
+	}
+	if c.aboveHighWater() {
+		return ErrWouldBlock
+	}
+
+	c.queueWrite(data)
+	return nil
+}
+
+// queueWrite 在 loop 中经 Protocol.Packet 编码后写出，不检查水位
+func (c *Connection) queueWrite(data interface{}) {
+	var n int64
+	if b, ok := data.([]byte); ok {
+		n = int64(len(b))
+	}
+	c.enqueue(pendingSend{data: data, n: n})
+}
+
+// Close 关闭连接，对端已关闭写端（HalfClose）时先写完 outBuf 再 SHUT_WR 并关闭
+func (c *Connection) Close() error {
+	if !c.connected.Load() {
+		return ErrConnectionClosed
+	}
 
 	c.loop.QueueInLoop(func() {
-		if c.connected.Load() {
-			c.sendInLoop(c.protocol.Packet(c, data))
+		if c.readClosed.Load() {
+			c.closeAfterFlush(CloseLocal)
+			return
 		}
+		c.closeWith(CloseLocal, nil)
 	})
 	return nil
 }
 
-func (c *Connection) Close() error {
+// CloseWithError 上报错误并关闭连接，供 Protocol 等在 loop 外无法处理的错误使用
+func (c *Connection) CloseWithError(err error) {
+	c.reportError(OpProtocol, err)
+	_ = c.closeAsync(CloseProtocolError, err)
+}
+
+// notifyConnect 回调 OnConnect，只有回调过 OnConnect 的连接关闭时才回调 OnClose
+func (c *Connection) notifyConnect(h Handler) {
+	c.opened = true
+	h.OnConnect(c)
+}
+
+func (c *Connection) reportError(op Op, err error) {
+	if c.onError != nil {
+		c.onError(&OpError{Op: op, Conn: c, Err: err})
+	}
+}
+
+// PauseRead 停止从 socket 读取数据，可在任意 goroutine 中调用。
+// 暂停期间 outBuf 仍会继续写出，连接不会因空闲超时被关闭
+func (c *Connection) PauseRead() error {
 	if !c.connected.Load() {
 		return ErrConnectionClosed
 	}
+	if c.readPaused.Swap(true) {
+		return nil
+	}
 
 	c.loop.QueueInLoop(func() {
-		c.handleClose(c.fd)
+		if c.connected.Load() && !c.edgeTriggered {
+			if err := c.updateInterest(); err != nil {
+				c.reportError(OpRead, err)
+				c.closeWith(CloseReadError, err)
+			}
+		}
 	})
 	return nil
 }
 
-func (c *Connection) ShutdownWrite() error {
-	return unix.Shutdown(c.fd, unix.SHUT_WR)
+// ResumeRead 恢复读取，可在任意 goroutine 中调用
+func (c *Connection) ResumeRead() error {
+	if !c.connected.Load() {
+		return ErrConnectionClosed
+	}
+	if !c.readPaused.Swap(false) {
+		return nil
+	}
+	c.touchRead()
+
+	c.loop.QueueInLoop(func() {
+		if !c.connected.Load() || c.readPaused.Load() {
+			return
+		}
+		if c.edgeTriggered {
+			// 暂停期间到达的数据不会再触发事件
+			c.handleEventET(c.fd, poller.EventNone)
+			return
+		}
+		if err := c.updateInterest(); err != nil {
+			c.reportError(OpRead, err)
+			c.closeWith(CloseReadError, err)
+		}
+	})
+	return nil
+}
+
+// ReadPaused 是否已调用 PauseRead 暂停读取
+func (c *Connection) ReadPaused() bool {
+	return c.readPaused.Load()
+}
+
+// updateInterest 按 outBuf 和暂停状态设置关注的事件，只用于水平触发。
+// 以完成事件收发时没有关注的事件，可以读取时提交 recv
+func (c *Connection) updateInterest() error {
+	if c.completion != nil {
+		c.recvCompletion()
+		return nil
+	}
+	paused := c.readPaused.Load() || c.readClosed.Load()
+	switch {
+	case c.writePending() && paused:
+		return c.loop.EnableWrite(c.fd)
+	case c.writePending():
+		return c.loop.EnableReadWrite(c.fd)
+	case paused:
+		return c.loop.DisableRead(c.fd)
+	}
+	return c.loop.EnableRead(c.fd)
 }
 
 func (c *Connection) ReadBufLen() int64 {
@@ -145,16 +327,21 @@ func (c *Connection) WriteBufLen() int64 {
 
 // internal use, eventloop callback
 func (c *Connection) HandleEvent(fd int, events poller.Event) {
-	if c.idleTime > 0 {
-		_ = c.activeTime.Swap(time.Now().Unix())
+	if c.relay != nil && !c.relay.done {
+		c.relay.handleEvent(c, events)
+		return
+	}
+	if c.edgeTriggered {
+		c.handleEventET(fd, events)
+		return
 	}
 
 	if events&poller.EventErr != 0 {
-		c.handleClose(fd)
+		c.closeWith(ClosePollError, nil)
 		return
 	}
 
-	if !c.outBuf.IsEmpty() {
+	if c.writePending() {
 		if events&poller.EventWrite != 0 {
 			if c.handleWrite(fd) {
 				return
@@ -163,7 +350,7 @@ func (c *Connection) HandleEvent(fd int, events poller.Event) {
 				c.outBuf.Reset()
 			}
 		}
-	} else if events&poller.EventRead != 0 {
+	} else if events&poller.EventRead != 0 && !c.readPaused.Load() && !c.readClosed.Load() {
 		if c.handleRead(fd) {
 			return
 		}
@@ -173,7 +360,7 @@ func (c *Connection) HandleEvent(fd int, events poller.Event) {
 	}
 
 	c.inBufLen.Swap(int64(c.inBuf.Length()))
-	c.outBufLen.Swap(int64(c.outBuf.Length()))
+	c.checkWriteBuffer()
 }
 
 func sockAddrToString(sa unix.Sockaddr) string {
@@ -182,6 +369,8 @@ func sockAddrToString(sa unix.Sockaddr) string {
 		return net.JoinHostPort(net.IP(sa.Addr[:]).String(), strconv.Itoa(sa.Port))
 	case *unix.SockaddrInet6:
 		return net.JoinHostPort(net.IP(sa.Addr[:]).String(), strconv.Itoa(sa.Port))
+	case *unix.SockaddrUnix:
+		return sa.Name
 	default:
 		return fmt.Sprintf("(unknown - %T)", sa)
 	}
@@ -190,19 +379,55 @@ func sockAddrToString(sa unix.Sockaddr) string {
 func (c *Connection) handleClose(fd int) {
 	if c.connected.Load() {
 		c.connected.Store(false)
-		c.loop.DeleteFdInLoop(fd)
-		c.callback.OnClose(c)
-		if err := unix.Close(fd); err != nil {
-			log.Fatal("[close fd]", err)
+		c.stopTimers()
+		if c.tls != nil {
+			c.closeTLS()
 		}
+		if c.completion == nil {
+			if err := c.loop.DeleteFdInLoop(fd); err != nil {
+				c.reportError(OpClose, err)
+			}
+		}
+		if c.opened {
+			if c.inflight.Load() > 0 {
+				// 异步模式下等已分发的 OnMessage 都返回后再回调，见 workerIdle
+				c.closeNotify = true
+			} else {
+				c.callback.OnClose(c)
+			}
+		}
+		if c.relay != nil {
+			c.relay.release(c)
+		}
+		if c.completion != nil {
+			// 已提交的 sendmsg 写完后由 poller 关闭 fd
+			if err := c.loop.CloseFdInLoop(fd); err != nil {
+				c.reportError(OpClose, err)
+			}
+		} else if err := unix.Close(fd); err != nil {
+			c.reportError(OpClose, err)
+		}
+
+		c.releaseResources()
+	}
+}
 
-		ringbuffer.PutInPool(c.inBuf)
+func (c *Connection) releaseResources() {
+	c.wakeWriters()
+	c.failFiles()
+	ringbuffer.PutInPool(c.inBuf)
+	if c.completion != nil && c.completion.sending {
+		// sendmsg 完成前内核仍会读取 outBuf
+		c.completion.release = true
+	} else {
 		ringbuffer.PutInPool(c.outBuf)
+	}
+	if c.onRelease != nil {
+		c.onRelease()
+	}
 
-		if v := c.timer.Load(); v != nil {
-			timer := v.(*timingwheel.Timer)
-			timer.Stop()
-		}
+	if timer := c.timer.Load(); timer != nil {
+		timer.Stop()
 	}
 }
 
@@ -211,11 +436,26 @@ func (c *Connection) handleRead(fd int) (closed bool) {
 	n, err := unix.Read(c.fd, buf)
 	if n == 0 || err != nil {
 		if err != unix.EAGAIN {
-			c.handleClose(fd)
-			closed = true
+			closed = c.closeRead(err)
 		}
 		return
 	}
+	return c.handleData(buf, n)
+}
+
+// handleData 处理读到 buf[:n] 中的数据，buf 剩余空间用作回复的缓冲区
+func (c *Connection) handleData(buf []byte, n int) (closed bool) {
+	c.touchRead()
+	if c.proxy != nil {
+		rest, ok := c.handleProxyHeader(buf[:n])
+		if !ok || len(rest) == 0 {
+			return !c.connected.Load()
+		}
+		n = copy(buf, rest)
+	}
+	if c.tls != nil {
+		return c.handleTLSRead(buf[:n])
+	}
 
 	if c.inBuf.IsEmpty() {
 		c.buf.WithData(buf[:n])
@@ -254,17 +494,30 @@ func (c *Connection) handleRead(fd int) (closed bool) {
 		c.handlerProtocol(&buf, c.inBuf)
 	}
 	if len(buf) != 0 {
-		closed = c.sendInLoop(buf)
+		if c.writeInLoop(buf) {
+			return true
+		}
 	}
 
-	return
+	return c.checkReadBuffer()
+}
+
+// checkReadBuffer Protocol 未取走的数据超过上限时关闭连接
+func (c *Connection) checkReadBuffer() (closed bool) {
+	if c.maxReadBuf > 0 && c.inBuf.Length() > c.maxReadBuf {
+		c.reportError(OpRead, ErrReadBufferFull)
+		c.closeWith(CloseBufferFull, ErrReadBufferFull)
+		return true
+	}
+	return false
 }
 
 func (c *Connection) handlerProtocol(tmpBuffer *[]byte, buffer *ringbuffer.RingBuffer) {
 	ctx, receivedData := c.protocol.UnPacket(c, buffer)
 	for ctx != nil || len(receivedData) != 0 {
-		sendData := c.callback.OnMessage(c, ctx, receivedData)
-		if sendData != nil {
+		if c.worker != nil {
+			c.dispatch(ctx, receivedData)
+		} else if sendData := c.callback.OnMessage(c, ctx, receivedData); sendData != nil {
 			*tmpBuffer = append(*tmpBuffer, c.protocol.Packet(c, sendData)...)
 		}
 
@@ -294,57 +547,82 @@ func (c *Connection) recv() []byte {
 }
 
 func (c *Connection) handleWrite(fd int) (closed bool) {
-	ft, ed := c.outBuf.PeekAll()
-	n, err := unix.Write(c.fd, ft)
-	if err != nil {
+	if _, err := c.writeOut(c.fd); err != nil {
 		if err == unix.EAGAIN {
 			return
 		}
-		c.handleClose(fd)
+		c.closeWith(CloseWriteError, err)
 		closed = true
 		return
 	}
-	c.outBuf.Retrieve(n)
 
-	if n == len(ft) && len(ed) > 0 {
-		n, err = unix.Write(c.fd, ed)
-		if err != nil {
-			if err == unix.EAGAIN {
-				return
-			}
-			c.handleClose(fd)
+	if !c.writePending() {
+		if c.outBufDrained() {
 			closed = true
 			return
 		}
-		c.outBuf.Retrieve(n)
-	}
-
-	if c.outBuf.IsEmpty() {
-		if err := c.loop.EnableRead(fd); err != nil {
-			log.Fatal("[enableRead]", err)
+		if err := c.updateInterest(); err != nil {
+			c.reportError(OpWrite, err)
+			c.closeWith(CloseWriteError, err)
+			closed = true
 		}
 	}
 
 	return
 }
 
+// shutdownInLoop 标记连接进入关闭流程，outBuf 写完后关闭
+func (c *Connection) shutdownInLoop() {
+	c.draining = true
+	c.drainReason = CloseServerStop
+	if c.shutdownHook != nil && c.opened {
+		c.shutdownHook.OnShutdown(c)
+	}
+
+	// 排在 OnShutdown 中 Send 的任务之后执行
+	c.loop.QueueInLoop(func() {
+		if c.connected.Load() && !c.drainPending() {
+			c.closeDrained()
+		}
+	})
+}
+
+// writeInLoop 写出应用数据，TLS 连接先加密
+func (c *Connection) writeInLoop(data []byte) (closed bool) {
+	if c.tls != nil {
+		return c.writeTLS(data)
+	}
+	return c.sendInLoop(data)
+}
+
 func (c *Connection) sendInLoop(data []byte) (closed bool) {
-	if !c.outBuf.IsEmpty() {
+	if c.completion != nil {
+		return c.writeCompletion(data)
+	}
+	if c.writePending() {
 		c.outBuf.Write(data)
 	} else {
 		n, err := unix.Write(c.fd, data)
 		if err != nil && err != unix.EAGAIN {
-			c.handleClose(c.fd)
+			c.closeWith(CloseWriteError, err)
 			closed = true
 			return
 		}
+		if n > 0 {
+			c.touchWrite()
+		}
 		if n <= 0 {
 			c.outBuf.Write(data)
 		} else if n < len(data) {
 			c.outBuf.Write(data[n:])
 		}
-		if !c.outBuf.IsEmpty() {
-			c.loop.EnableReadWrite(c.fd)
+		// 边沿触发时已注册写事件
+		if c.writePending() && !c.edgeTriggered {
+			if err := c.updateInterest(); err != nil {
+				c.reportError(OpWrite, err)
+				c.closeWith(CloseWriteError, err)
+				closed = true
+			}
 		}
 	}
 	return
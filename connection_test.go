@@ -1,6 +1,7 @@
 package goreaction
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -59,3 +60,158 @@ func TestConnClose(t *testing.T) {
 	fmt.Println(buf)
 	s.Stop()
 }
+
+type errorExample struct {
+	serverTest
+}
+
+func (s *errorExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	c.CloseWithError(errors.New("bad message"))
+	return
+}
+
+func TestConnCloseWithError(t *testing.T) {
+	handler := new(errorExample)
+	errs := make(chan *OpError, 1)
+
+	s, err := NewServer(handler,
+		Address("127.0.0.1:12349"),
+		OnError(func(err *OpError) {
+			errs <- err
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:12349", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 8)
+	if n, err := conn.Read(buf); n != 0 || err != io.EOF {
+		t.Fatal(n, err)
+	}
+
+	select {
+	case e := <-errs:
+		if e.Op != OpProtocol || e.Conn == nil || e.Err.Error() != "bad message" {
+			t.Fatal(e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("error handler not called")
+	}
+}
+
+type pauseExample struct {
+	serverTest
+	conns chan *Connection
+	msgs  chan string
+}
+
+func (s *pauseExample) OnConnect(c *Connection) {
+	s.conns <- c
+}
+
+func (s *pauseExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	s.msgs <- string(data)
+	return
+}
+
+func TestConnPauseRead(t *testing.T) {
+	for i, opts := range [][]Option{nil, {EdgeTriggered(0)}} {
+		addr := fmt.Sprintf("127.0.0.1:%d", 12370+i)
+		handler := &pauseExample{conns: make(chan *Connection, 1), msgs: make(chan string, 16)}
+		s, err := NewServer(handler, append(opts, Address(addr), NumLoops(1))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go s.Start()
+		time.Sleep(100 * time.Millisecond)
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := <-handler.conns
+		if err := c.PauseRead(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-handler.msgs:
+			t.Fatalf("paused connection should not read, but got %q", msg)
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		// 暂停期间仍可写出
+		if err := c.Send([]byte("pong")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "pong" {
+			t.Fatalf("read should be pong, but %q %v", buf, err)
+		}
+
+		if err := c.ResumeRead(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-handler.msgs:
+			if msg != "ping" {
+				t.Fatalf("message should be ping, but %q", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("resumed connection should read pending data")
+		}
+
+		_ = conn.Close()
+		s.Stop()
+	}
+}
+
+func TestConnPauseReadIdle(t *testing.T) {
+	handler := &pauseExample{conns: make(chan *Connection, 1), msgs: make(chan string, 16)}
+	s, err := NewServer(handler, Address("127.0.0.1:12372"), NumLoops(1), IdleTime(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12372")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := <-handler.conns
+	if err := c.PauseRead(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2500 * time.Millisecond)
+	if !c.Connected() {
+		t.Fatal("paused connection should not be closed as idle")
+	}
+
+	if err := c.ResumeRead(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2500 * time.Millisecond)
+	if c.Connected() {
+		t.Fatal("idle connection should be closed after resume")
+	}
+}
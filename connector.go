@@ -0,0 +1,387 @@
+package goreaction
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"goreaction/eventloop"
+	"goreaction/poller"
+	"math"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrConnectTimeout   = errors.New("connect timeout")
+	ErrConnectorStopped = errors.New("connector stopped")
+)
+
+// ConnectFailedHandler Dial 的 handler 可选实现，每次连接失败时在连接分配到的 loop 中回调
+type ConnectFailedHandler interface {
+	OnConnectFailed(addr string, err error)
+}
+
+// ReconnectPolicy 连接失败或断开后按指数退避重连
+type ReconnectPolicy struct {
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Multiplier 每次失败后退避时间的倍数，默认 2
+	Multiplier float64
+	// Jitter 退避时间的随机浮动比例 [0, 1]
+	Jitter float64
+	// MaxAttempts 连续重连的最大次数，0 表示不限制
+	MaxAttempts int
+}
+
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	min, max, mul := p.MinBackoff, p.MaxBackoff, p.Multiplier
+	if min <= 0 {
+		min = 100 * time.Millisecond
+	}
+	if max < min {
+		max = min
+	}
+	if mul < 1 {
+		mul = 2
+	}
+
+	d := float64(min) * math.Pow(mul, float64(attempt))
+	if d > float64(max) {
+		d = float64(max)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+type dialOptions struct {
+	timeout   time.Duration
+	reconnect *ReconnectPolicy
+	loop      *eventloop.EventLoop
+}
+
+type DialOption func(*dialOptions)
+
+// DialTimeout connect 超时时间，0 表示不超时
+func DialTimeout(d time.Duration) DialOption {
+	return func(o *dialOptions) {
+		o.timeout = d
+	}
+}
+
+// Reconnect 开启自动重连
+func Reconnect(p ReconnectPolicy) DialOption {
+	return func(o *dialOptions) {
+		o.reconnect = &p
+	}
+}
+
+// DialSameLoop 出站连接注册在 c 所在的 loop 上，用于 NewRelay 等需要两个连接在同一 loop 的场景
+func DialSameLoop(c *Connection) DialOption {
+	return func(o *dialOptions) {
+		o.loop = c.loop
+	}
+}
+
+// Connector 由 Server.Dial 创建的出站连接，连接注册在 server 的 work loop 上
+type Connector struct {
+	server   *Server
+	network  string
+	addr     string
+	handler  Handler
+	opts     dialOptions
+	attempts atomic.Int32
+	stopped  atomic.Bool
+	conn     atomic.Pointer[Connection]
+	pending  atomic.Pointer[pendingConn]
+}
+
+// Dial 非阻塞连接 addr，成功时回调 handler.OnConnect，失败时回调 ConnectFailedHandler。
+// network 支持 tcp、tcp4、tcp6、unix。未开启重连时地址解析、创建 socket 的错误由 Dial 直接返回，
+// 开启重连时同样作为一次失败回调并重连
+func (s *Server) Dial(network, addr string, handler Handler, opts ...DialOption) (*Connector, error) {
+	if handler == nil {
+		return nil, errors.New("handler is nil")
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return nil, errors.New("unsupported network: " + network)
+	}
+	if s.closed.Load() {
+		return nil, ErrServerClosed
+	}
+
+	cn := &Connector{
+		server:  s,
+		network: network,
+		addr:    addr,
+		handler: handler,
+	}
+	for _, o := range opts {
+		o(&cn.opts)
+	}
+
+	if cn.opts.reconnect == nil {
+		pc, err := cn.dial()
+		if err != nil {
+			return nil, err
+		}
+		cn.register(pc)
+		return cn, nil
+	}
+	cn.connect()
+	return cn, nil
+}
+
+// Connection 当前已建立的连接，未连接时返回 nil
+func (cn *Connector) Connection() *Connection {
+	return cn.conn.Load()
+}
+
+// Stop 停止重连，取消进行中的 connect 并关闭当前连接
+func (cn *Connector) Stop() {
+	cn.stopped.Store(true)
+	// 先取 pending，connect 完成时先设置 conn 再清除 pending
+	if pc := cn.pending.Load(); pc != nil {
+		pc.loop.QueueInLoop(func() {
+			if !pc.done {
+				pc.finish(ErrConnectorStopped)
+				return
+			}
+			// connect 在 Stop 之前完成
+			if c := cn.conn.Load(); c != nil {
+				c.closeWith(CloseLocal, nil)
+			}
+		})
+	}
+	if c := cn.conn.Load(); c != nil {
+		_ = c.Close()
+	}
+}
+
+// connect 发起一次连接，失败时转到 loop 中回调。地址解析可能阻塞，不能在 loop 或时间轮中调用
+func (cn *Connector) connect() {
+	if cn.stopped.Load() || cn.server.closed.Load() {
+		return
+	}
+
+	pc, err := cn.dial()
+	if err != nil {
+		cn.nextLoop(nil).QueueInLoop(func() {
+			cn.failed(err)
+		})
+		return
+	}
+	cn.register(pc)
+}
+
+// dial 解析地址并发起非阻塞 connect
+func (cn *Connector) dial() (*pendingConn, error) {
+	sa, domain, err := resolveSockaddr(cn.network, cn.addr)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(domain, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = unix.Connect(fd, sa); err != nil && err != unix.EINPROGRESS {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	return &pendingConn{fd: fd, sa: sa, loop: cn.nextLoop(sa), connector: cn}, nil
+}
+
+// nextLoop 连接注册的 loop，解析地址失败时 sa 为 nil
+func (cn *Connector) nextLoop(sa unix.Sockaddr) *eventloop.EventLoop {
+	if cn.opts.loop != nil {
+		return cn.opts.loop
+	}
+	return cn.server.opts.LoadBalancer.Next(cn.server.workLoops, sa)
+}
+
+// register 在 loop 中注册 connect 中的 socket，等待可写
+func (cn *Connector) register(pc *pendingConn) {
+	loop, fd := pc.loop, pc.fd
+	cn.pending.Store(pc)
+	loop.QueueInLoop(func() {
+		if pc.done {
+			// 注册前已被 Stop 取消
+			return
+		}
+		if err := loop.AddSocketAndEnableWrite(fd, pc); err != nil {
+			pc.done = true
+			cn.pending.CompareAndSwap(pc, nil)
+			_ = unix.Close(fd)
+			cn.failed(err)
+			return
+		}
+		pc.added = true
+		if cn.opts.timeout > 0 {
+			pc.timer = loop.RunAfter(cn.opts.timeout, func() {
+				pc.finish(ErrConnectTimeout)
+			})
+		}
+	})
+}
+
+func (cn *Connector) connected(c *Connection) {
+	cn.attempts.Store(0)
+	cn.conn.Store(c)
+	c.notifyConnect(cn.handler)
+}
+
+func (cn *Connector) failed(err error) {
+	if h, ok := cn.handler.(ConnectFailedHandler); ok {
+		h.OnConnectFailed(cn.addr, err)
+	}
+	cn.scheduleReconnect()
+}
+
+func (cn *Connector) scheduleReconnect() {
+	p := cn.opts.reconnect
+	if p == nil || cn.stopped.Load() || cn.server.closed.Load() {
+		return
+	}
+	attempt := int(cn.attempts.Add(1))
+	if p.MaxAttempts > 0 && attempt > p.MaxAttempts {
+		return
+	}
+	// 时间轮只负责定时，解析地址等可能阻塞的操作放到单独的 goroutine
+	cn.server.timingWheel.AfterFunc(p.backoff(attempt-1), func() {
+		go cn.connect()
+	})
+}
+
+// connectorCallback 连接断开时触发重连
+type connectorCallback struct {
+	*Connector
+}
+
+func (cb connectorCallback) OnMessage(c *Connection, ctx interface{}, data []byte) interface{} {
+	return cb.handler.OnMessage(c, ctx, data)
+}
+
+func (cb connectorCallback) OnClose(c *Connection) {
+	cb.conn.CompareAndSwap(c, nil)
+	cb.handler.OnClose(c)
+	cb.scheduleReconnect()
+}
+
+// pendingConn connect 尚未完成的 socket
+type pendingConn struct {
+	fd        int
+	sa        unix.Sockaddr
+	loop      *eventloop.EventLoop
+	connector *Connector
+	timer     *eventloop.Timer
+	added     bool
+	done      bool
+}
+
+// internal use, eventloop callback
+func (pc *pendingConn) HandleEvent(fd int, events poller.Event) {
+	if pc.done {
+		return
+	}
+	if pc.connector.stopped.Load() {
+		pc.finish(ErrConnectorStopped)
+		return
+	}
+	soErr, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err == nil && soErr != 0 {
+		err = unix.Errno(soErr)
+	}
+	if err != nil {
+		pc.finish(err)
+		return
+	}
+
+	pc.done = true
+	pc.stopTimer()
+	cn := pc.connector
+	s := cn.server
+	c := NewConnection(fd, pc.loop, pc.sa, s.opts, connectorCallback{cn})
+	s.useWorker(c)
+	if c.completion != nil {
+		err = pc.loop.ReplaceSocketCompletion(fd, c)
+	} else {
+		err = pc.loop.ReplaceSocket(fd, c)
+	}
+	if err != nil {
+		s.opts.ErrorHandler(&OpError{Op: OpRegister, Conn: c, Err: err})
+		c.connected.Store(false)
+		_ = pc.loop.DeleteFdInLoop(fd)
+		_ = unix.Close(fd)
+		c.releaseResources()
+		cn.pending.CompareAndSwap(pc, nil)
+		cn.failed(err)
+		return
+	}
+	if c.completion != nil {
+		c.startCompletion()
+	}
+	cn.connected(c)
+	cn.pending.CompareAndSwap(pc, nil)
+}
+
+// finish 以 err 结束 connect，只能在 loop 中调用
+func (pc *pendingConn) finish(err error) {
+	if pc.done {
+		return
+	}
+	pc.done = true
+	pc.stopTimer()
+	pc.connector.pending.CompareAndSwap(pc, nil)
+	if pc.added {
+		_ = pc.loop.DeleteFdInLoop(pc.fd)
+	}
+	_ = unix.Close(pc.fd)
+	pc.connector.failed(err)
+}
+
+func (pc *pendingConn) stopTimer() {
+	if pc.timer != nil {
+		pc.timer.Stop()
+	}
+}
+
+func (pc *pendingConn) Close() error {
+	if pc.done {
+		return nil
+	}
+	pc.done = true
+	pc.stopTimer()
+	return unix.Close(pc.fd)
+}
+
+func resolveSockaddr(network, addr string) (unix.Sockaddr, int, error) {
+	if network == "unix" {
+		return &unix.SockaddrUnix{Name: addr}, unix.AF_UNIX, nil
+	}
+
+	tcpAddr, err := net.ResolveTCPAddr(network, addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ip4 := tcpAddr.IP.To4(); ip4 != nil && network != "tcp6" {
+		sa := &unix.SockaddrInet4{Port: tcpAddr.Port}
+		copy(sa.Addr[:], ip4)
+		return sa, unix.AF_INET, nil
+	}
+	if tcpAddr.IP == nil {
+		// 未指定 host 时连接本机
+		return &unix.SockaddrInet4{Port: tcpAddr.Port, Addr: [4]byte{127, 0, 0, 1}}, unix.AF_INET, nil
+	}
+	sa := &unix.SockaddrInet6{Port: tcpAddr.Port}
+	copy(sa.Addr[:], tcpAddr.IP.To16())
+	if tcpAddr.Zone != "" {
+		if ifi, err := net.InterfaceByName(tcpAddr.Zone); err == nil {
+			sa.ZoneId = uint32(ifi.Index)
+		}
+	}
+	return sa, unix.AF_INET6, nil
+}
@@ -0,0 +1,269 @@
+package goreaction
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type dialExample struct {
+	connected chan *Connection
+	messages  chan string
+	failed    chan error
+	closed    atomic.Int32
+}
+
+func newDialExample() *dialExample {
+	return &dialExample{
+		connected: make(chan *Connection, 8),
+		messages:  make(chan string, 8),
+		failed:    make(chan error, 8),
+	}
+}
+
+func (s *dialExample) OnConnect(c *Connection) {
+	s.connected <- c
+	if err := c.Send([]byte("ping")); err != nil {
+		panic(err)
+	}
+}
+
+func (s *dialExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	s.messages <- string(data)
+	return
+}
+
+func (s *dialExample) OnClose(c *Connection) {
+	s.closed.Add(1)
+}
+
+func (s *dialExample) OnConnectFailed(addr string, err error) {
+	select {
+	case s.failed <- err:
+	default:
+	}
+}
+
+func newDialServer(t *testing.T, addr string) *Server {
+	s, err := NewServer(new(serverTest), Address(addr), NumLoops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	time.Sleep(100 * time.Millisecond)
+	return s
+}
+
+func TestServer_Dial(t *testing.T) {
+	backend := newDialServer(t, "127.0.0.1:12352")
+	defer backend.Stop()
+	s := newDialServer(t, "127.0.0.1:12353")
+	defer s.Stop()
+
+	handler := newDialExample()
+	cn, err := s.Dial("tcp", "127.0.0.1:12352", handler, DialTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case c := <-handler.connected:
+		assert.Equal(t, "127.0.0.1:12352", c.PeerAddr())
+		assert.Equal(t, c, cn.Connection())
+	case err := <-handler.failed:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("connect timeout")
+	}
+
+	select {
+	case msg := <-handler.messages:
+		assert.Equal(t, "ping", msg)
+	case <-time.After(time.Second):
+		t.Fatal("echo timeout")
+	}
+
+	cn.Stop()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), handler.closed.Load())
+	assert.Nil(t, cn.Connection())
+}
+
+func TestServer_DialReconnect(t *testing.T) {
+	s := newDialServer(t, "127.0.0.1:12354")
+	defer s.Stop()
+
+	handler := newDialExample()
+	_, err := s.Dial("tcp", "127.0.0.1:12355", handler, Reconnect(ReconnectPolicy{
+		MinBackoff:  10 * time.Millisecond,
+		MaxBackoff:  40 * time.Millisecond,
+		Jitter:      0.2,
+		MaxAttempts: 3,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		select {
+		case err := <-handler.failed:
+			assert.True(t, errors.Is(err, unix.ECONNREFUSED), err)
+		case <-time.After(time.Second):
+			t.Fatalf("attempt %d not reported", i)
+		}
+	}
+	select {
+	case err := <-handler.failed:
+		t.Fatal("should stop after MaxAttempts", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// 后端启动后重连成功
+	_, err = s.Dial("tcp", "127.0.0.1:12355", handler, Reconnect(ReconnectPolicy{
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 40 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-handler.failed
+	backend := newDialServer(t, "127.0.0.1:12355")
+	defer backend.Stop()
+	select {
+	case <-handler.connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reconnect timeout")
+	}
+}
+
+func TestServer_DialResolveError(t *testing.T) {
+	s := newDialServer(t, "127.0.0.1:12403")
+	defer s.Stop()
+
+	// 未开启重连时由 Dial 直接返回，不回调 OnConnectFailed
+	handler := newDialExample()
+	cn, err := s.Dial("tcp", "127.0.0.1:bad", handler)
+	assert.Error(t, err)
+	assert.Nil(t, cn)
+	select {
+	case err := <-handler.failed:
+		t.Fatal("OnConnectFailed should not be called", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 开启重连时在 loop 中回调，重连在时间轮之外解析地址
+	_, err = s.Dial("tcp", "127.0.0.1:bad", handler, Reconnect(ReconnectPolicy{
+		MinBackoff:  10 * time.Millisecond,
+		MaxAttempts: 2,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-handler.failed:
+			assert.Error(t, err)
+		case <-time.After(time.Second):
+			t.Fatalf("attempt %d not reported", i)
+		}
+	}
+}
+
+func TestReconnectPolicy_Backoff(t *testing.T) {
+	p := ReconnectPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	assert.Equal(t, 10*time.Millisecond, p.backoff(0))
+	assert.Equal(t, 20*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 40*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 50*time.Millisecond, p.backoff(3))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.backoff(1)
+		assert.True(t, d >= 10*time.Millisecond && d <= 30*time.Millisecond, d)
+	}
+}
+
+func TestConnector_StopPending(t *testing.T) {
+	backend := newDialServer(t, "127.0.0.1:12393")
+	defer backend.Stop()
+	s := newDialServer(t, "127.0.0.1:12394")
+	defer s.Stop()
+
+	for i := 0; i < 20; i++ {
+		handler := newDialExample()
+		cn, err := s.Dial("tcp", "127.0.0.1:12393", handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cn.Stop()
+
+		select {
+		case err := <-handler.failed:
+			assert.Equal(t, ErrConnectorStopped, err)
+		case c := <-handler.connected:
+			// connect 在 Stop 之前已完成时连接应被关闭
+			time.Sleep(50 * time.Millisecond)
+			assert.False(t, c.Connected())
+		case <-time.After(time.Second):
+			t.Fatal("pending connect not cancelled")
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	for _, l := range s.workLoops {
+		assert.Equal(t, int64(0), l.ConnectionCount())
+	}
+}
+
+type dialShutdownExample struct {
+	*dialExample
+	shutdown chan *Connection
+}
+
+func (s *dialShutdownExample) OnShutdown(c *Connection) {
+	s.shutdown <- c
+}
+
+type shutdownCounter struct {
+	serverTest
+	calls atomic.Int32
+}
+
+func (s *shutdownCounter) OnShutdown(c *Connection) {
+	s.calls.Add(1)
+}
+
+func TestServer_DialHooks(t *testing.T) {
+	backend := newDialServer(t, "127.0.0.1:12395")
+	defer backend.Stop()
+
+	serverHandler := new(shutdownCounter)
+	s, err := NewServer(serverHandler, Address("127.0.0.1:12396"), NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	handler := &dialShutdownExample{dialExample: newDialExample(), shutdown: make(chan *Connection, 1)}
+	if _, err := s.Dial("tcp", "127.0.0.1:12395", handler); err != nil {
+		t.Fatal(err)
+	}
+	c := <-handler.connected
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-handler.shutdown:
+		assert.Equal(t, c, got)
+	default:
+		t.Fatal("OnShutdown of the dial handler not called")
+	}
+	assert.Equal(t, int32(0), serverHandler.calls.Load())
+}
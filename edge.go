@@ -0,0 +1,86 @@
+package goreaction
+
+import (
+	"golang.org/x/sys/unix"
+	"goreaction/poller"
+)
+
+// DefaultEdgeTriggeredBudget 边沿触发模式下每个连接一轮最多读取的次数
+const DefaultEdgeTriggeredBudget = 16
+
+// handleEventET 边沿触发模式下事件只通知一次，读写都要持续到 EAGAIN，
+// 未读完的数据记录在 readPending 中
+func (c *Connection) handleEventET(fd int, events poller.Event) {
+	if events&poller.EventErr != 0 {
+		c.closeWith(ClosePollError, nil)
+		return
+	}
+	if events&poller.EventRead != 0 {
+		c.readPending = true
+	}
+
+	if events&poller.EventWrite != 0 && c.writePending() {
+		if c.handleWriteET(fd) {
+			return
+		}
+	}
+	// 与水平触发一致，outBuf 写完之前不再读取，写完后继续读取剩余数据
+	if c.readPending && !c.writePending() && !c.readPaused.Load() && !c.readClosed.Load() {
+		if c.handleReadET(fd) {
+			return
+		}
+	}
+
+	if c.inBuf.IsEmpty() {
+		c.inBuf.Reset()
+	}
+	if c.outBuf.IsEmpty() {
+		c.outBuf.Reset()
+	}
+	c.inBufLen.Swap(int64(c.inBuf.Length()))
+	c.checkWriteBuffer()
+}
+
+// handleReadET 读取直到 EAGAIN，连续读取 budget 次后让出 loop，剩余数据在之后的任务中继续读取
+func (c *Connection) handleReadET(fd int) (closed bool) {
+	buf := c.loop.PacketBuf()
+	for i := 0; i < c.budget; i++ {
+		n, err := unix.Read(fd, buf)
+		if err == unix.EAGAIN {
+			c.readPending = false
+			return
+		}
+		if n == 0 || err != nil {
+			return c.closeRead(err)
+		}
+		if c.handleData(buf, n) {
+			return true
+		}
+		if c.writePending() || c.readPaused.Load() {
+			// 对端接收变慢时等待写事件，暂停时等待 ResumeRead
+			return
+		}
+	}
+
+	c.loop.QueueInLoop(func() {
+		if c.connected.Load() {
+			c.handleEventET(fd, poller.EventNone)
+		}
+	})
+	return
+}
+
+// handleWriteET 写出 outBuf 和文件直到写完或 EAGAIN，写事件已注册，无需修改关注的事件
+func (c *Connection) handleWriteET(fd int) (closed bool) {
+	all, err := c.writeOut(fd)
+	if err != nil && err != unix.EAGAIN {
+		c.closeWith(CloseWriteError, err)
+		return true
+	}
+	if !all {
+		// 发送缓冲区已满，有空间时会再次触发
+		return !c.connected.Load()
+	}
+
+	return c.outBufDrained()
+}
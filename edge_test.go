@@ -0,0 +1,85 @@
+package goreaction
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+type echoExample struct {
+	serverTest
+}
+
+func (s *echoExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	return data
+}
+
+func TestEdgeTriggered(t *testing.T) {
+	handler := new(echoExample)
+
+	s, err := NewServer(handler, Address("127.0.0.1:12367"), NumLoops(1), EdgeTriggered(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	bulk, err := net.Dial("tcp", "127.0.0.1:12367")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bulk.Close()
+	ping, err := net.Dial("tcp", "127.0.0.1:12367")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ping.Close()
+
+	// 大量数据需要多轮读取，并触发写缓冲区满后的写事件
+	msg := make([]byte, 8*1024*1024)
+	rand.Read(msg)
+	go func() {
+		_, _ = bulk.Write(msg)
+	}()
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, len(msg))
+		_, err := io.ReadFull(bulk, buf)
+		if err == nil && !bytes.Equal(buf, msg) {
+			err = io.ErrUnexpectedEOF
+		}
+		done <- err
+	}()
+
+	// 同一 loop 上的其他连接不会被饿死
+	for i := 0; i < 10; i++ {
+		_ = ping.SetDeadline(time.Now().Add(time.Second))
+		if _, err := ping.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(ping, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("bulk echo timeout")
+	}
+
+	_ = bulk.Close()
+	_ = ping.Close()
+	time.Sleep(100 * time.Millisecond)
+	if n := handler.Count.Load(); n != 0 {
+		t.Fatalf("connections should be closed, but %d", n)
+	}
+}
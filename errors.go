@@ -0,0 +1,45 @@
+package goreaction
+
+import (
+	"fmt"
+	"log"
+)
+
+// Op 出错的操作
+type Op string
+
+const (
+	OpAccept   Op = "accept"
+	OpRegister Op = "register"
+	OpRead     Op = "read"
+	OpWrite    Op = "write"
+	OpClose    Op = "close"
+	OpLoop     Op = "eventloop"
+	OpProtocol Op = "protocol"
+	OpTLS      Op = "tls"
+)
+
+// OpError 反应堆内部的非致命错误，Conn 为出错的连接，与连接无关时为 nil
+type OpError struct {
+	Op   Op
+	Conn *Connection
+	Err  error
+}
+
+func (e *OpError) Error() string {
+	if e.Conn != nil {
+		return fmt.Sprintf("%s %s: %v", e.Op, e.Conn.PeerAddr(), e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandler 接收反应堆内部错误，出错的连接会被关闭，loop 继续运行
+type ErrorHandler func(err *OpError)
+
+func defaultErrorHandler(err *OpError) {
+	log.Println("[goreaction]", err)
+}
@@ -1,6 +1,8 @@
 package eventloop
 
 import (
+	"errors"
+	"fmt"
 	"goreaction/poller"
 	"goreaction/utils"
 	"log"
@@ -24,24 +26,33 @@ type EventLoop struct {
 	// nolint
 	// Prevents false sharing on widespread platforms with
 	// 128 mod (cache line size) = 0 .
-	pad [128 - unsafe.Sizeof(eventLoopLocal{})%128]byte
+	// eventLoopLocal 已是 128 的整数倍时不填充
+	pad [(128 - unsafe.Sizeof(eventLoopLocal{})%128) % 128]byte
 }
 
 type eventLoopLocal struct {
 	ConnCnt    atomic.Int64
 	needWake   *atomic.Bool
-	poll       *poller.Poller
+	poll       poller.Poller
 	mu         utils.SpinLock
 	sockets    map[int]Socket
 	packet     []byte
 	taskQueueW []func()
 	taskQueueR []func()
+	onError    func(err error)
+	timers     timerHeap
+	timer      *timerSocket
 
 	UserBuf *[]byte
 }
 
 func New() (*EventLoop, error) {
-	p, err := poller.New()
+	return NewWithBackend(poller.BackendEpoll)
+}
+
+// NewWithBackend 使用指定的 poller 后端创建 loop
+func NewWithBackend(b poller.Backend) (*EventLoop, error) {
+	p, err := poller.NewBackend(b)
 	if err != nil {
 		return nil, err
 	}
@@ -57,10 +68,24 @@ func New() (*EventLoop, error) {
 			needWake:   nw,
 			taskQueueW: make([]func(), 0, DefaultTaskQueueSize),
 			taskQueueR: make([]func(), 0, DefaultTaskQueueSize),
+			onError:    defaultErrorHandler,
 		},
 	}, nil
 }
 
+func defaultErrorHandler(err error) {
+	log.Println("[eventloop]", err)
+}
+
+// SetErrorHandler 设置 loop 及其 poller 非致命错误的回调，需在 Run 之前调用
+func (el *EventLoop) SetErrorHandler(h func(err error)) {
+	if h == nil {
+		h = defaultErrorHandler
+	}
+	el.onError = h
+	el.poll.SetErrorHandler(h)
+}
+
 func (el *EventLoop) PacketBuf() []byte {
 	return el.packet
 }
@@ -69,12 +94,32 @@ func (el *EventLoop) ConnectionCount() int64 {
 	return el.ConnCnt.Load()
 }
 
-func (el *EventLoop) DeleteFdInLoop(fd int) {
-	if err := el.poll.Remove(fd); err != nil {
-		log.Fatal("[DeleteFdInLoop]", err)
+// DeleteFdInLoop 移除 fd，即使从 poller 移除失败也会从 loop 中删除
+func (el *EventLoop) DeleteFdInLoop(fd int) error {
+	err := el.poll.Remove(fd)
+	delete(el.sockets, fd)
+	el.ConnCnt.Add(-1)
+	return err
+}
+
+// CloseFdInLoop 移除 AddSocketCompletion 注册的 fd，由 poller 在已提交的请求完成后关闭 fd
+func (el *EventLoop) CloseFdInLoop(fd int) error {
+	var err error
+	if c := el.Completion(); c != nil {
+		err = c.CloseFd(fd)
+	} else {
+		err = errors.New("poller does not support completion")
 	}
 	delete(el.sockets, fd)
 	el.ConnCnt.Add(-1)
+	return err
+}
+
+// ForEachSocket 遍历 loop 中注册的 socket，只能在 loop 中调用
+func (el *EventLoop) ForEachSocket(f func(fd int, s Socket)) {
+	for fd, s := range el.sockets {
+		f(fd, s)
+	}
 }
 
 func (el *EventLoop) AddSocketAndEnableRead(fd int, s Socket) error {
@@ -88,6 +133,94 @@ func (el *EventLoop) AddSocketAndEnableRead(fd int, s Socket) error {
 	return nil
 }
 
+// AddSocketAndEnableWrite 注册 fd 并只监听写事件，用于等待非阻塞 connect 完成
+func (el *EventLoop) AddSocketAndEnableWrite(fd int, s Socket) error {
+	el.sockets[fd] = s
+	if err := el.poll.AddWrite(fd); err != nil {
+		delete(el.sockets, fd)
+		return err
+	}
+
+	el.ConnCnt.Add(1)
+	return nil
+}
+
+// EdgeTriggered poller 是否支持边沿触发
+func (el *EventLoop) EdgeTriggered() bool {
+	_, ok := el.poll.(poller.EdgeTriggered)
+	return ok
+}
+
+// AddSocketEdgeTriggered 以边沿触发注册 fd 的读写事件，poller 不支持时退回水平触发的读事件并返回 false
+func (el *EventLoop) AddSocketEdgeTriggered(fd int, s Socket) (bool, error) {
+	et, ok := el.poll.(poller.EdgeTriggered)
+	el.sockets[fd] = s
+	var err error
+	if ok {
+		err = et.AddEdgeTriggered(fd)
+	} else {
+		err = el.poll.AddRead(fd)
+	}
+	if err != nil {
+		delete(el.sockets, fd)
+		return false, err
+	}
+
+	el.ConnCnt.Add(1)
+	return ok, nil
+}
+
+// Completion poller 支持以完成事件收发时返回它，否则返回 nil
+func (el *EventLoop) Completion() poller.Completion {
+	c, _ := el.poll.(poller.Completion)
+	return c
+}
+
+// AddSocketCompletion 注册以完成事件收发的 fd，不关注就绪事件，读写由 Completion 提交
+func (el *EventLoop) AddSocketCompletion(fd int, s Socket) error {
+	c := el.Completion()
+	if c == nil {
+		return errors.New("poller does not support completion")
+	}
+	el.sockets[fd] = s
+	if err := c.AddCompletion(fd); err != nil {
+		delete(el.sockets, fd)
+		return err
+	}
+
+	el.ConnCnt.Add(1)
+	return nil
+}
+
+// AddListener 注册监听 socket，不计入 ConnectionCount
+func (el *EventLoop) AddListener(fd int, s Socket) error {
+	el.sockets[fd] = s
+	if err := el.poll.AddRead(fd); err != nil {
+		delete(el.sockets, fd)
+		return err
+	}
+	return nil
+}
+
+// RemoveListener 移除 AddListener 注册的 socket，只能在 loop 中调用
+func (el *EventLoop) RemoveListener(fd int) error {
+	err := el.poll.Remove(fd)
+	delete(el.sockets, fd)
+	return err
+}
+
+// ReplaceSocket 替换 fd 对应的 socket 并改为监听读事件，只能在 loop 中调用
+func (el *EventLoop) ReplaceSocket(fd int, s Socket) error {
+	el.sockets[fd] = s
+	return el.poll.EnableRead(fd)
+}
+
+// ReplaceSocketCompletion 替换 fd 对应的 socket 并不再关注就绪事件，之后读写由 Completion 提交，只能在 loop 中调用
+func (el *EventLoop) ReplaceSocketCompletion(fd int, s Socket) error {
+	el.sockets[fd] = s
+	return el.poll.DisableRead(fd)
+}
+
 func (el *EventLoop) EnableReadWrite(fd int) error {
 	return el.poll.EnableReadWrite(fd)
 }
@@ -96,6 +229,14 @@ func (el *EventLoop) EnableRead(fd int) error {
 	return el.poll.EnableRead(fd)
 }
 
+func (el *EventLoop) EnableWrite(fd int) error {
+	return el.poll.EnableWrite(fd)
+}
+
+func (el *EventLoop) DisableRead(fd int) error {
+	return el.poll.DisableRead(fd)
+}
+
 func (el *EventLoop) Run() {
 	el.poll.Poll(el.handlerEvent)
 }
@@ -104,7 +245,7 @@ func (el *EventLoop) Stop() error {
 	el.QueueInLoop(func() {
 		for _, v := range el.sockets {
 			if err := v.Close(); err != nil {
-				log.Fatal(err)
+				el.onError(fmt.Errorf("close socket: %w", err))
 			}
 		}
 		el.sockets = nil
@@ -121,7 +262,7 @@ func (el *EventLoop) QueueInLoop(f func()) {
 
 	if el.needWake.CompareAndSwap(true, false) {
 		if err := el.poll.Wake(); err != nil {
-			log.Fatal("QueueInLoop Wake loop, ", err)
+			el.onError(fmt.Errorf("QueueInLoop wake loop: %w", err))
 		}
 	}
 }
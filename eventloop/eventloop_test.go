@@ -36,5 +36,52 @@ func TestEventLoopSize(t *testing.T) {
 	t.Log(unsafe.Sizeof(eventLoopLocal{}))
 	t.Log(unsafe.Sizeof(EventLoop{}))
 
-	assert.Equal(t, 128, int(unsafe.Sizeof(EventLoop{})))
+	// eventLoopLocal 为 168 字节，填充到两个 128 字节
+	assert.Equal(t, 256, int(unsafe.Sizeof(EventLoop{})))
+}
+
+func TestEventLoop_Timer(t *testing.T) {
+	el, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go el.Run()
+	defer el.Stop()
+
+	// 回调在 loop 中按到期顺序执行，访问 order 无需加锁
+	var order []int
+	done := make(chan struct{})
+	el.RunAfter(60*time.Millisecond, func() {
+		order = append(order, 3)
+		close(done)
+	})
+	el.RunAfter(20*time.Millisecond, func() {
+		order = append(order, 1)
+	})
+	stopped := el.RunAfter(40*time.Millisecond, func() {
+		order = append(order, 2)
+	})
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+
+	ticks := 0
+	every := make(chan *Timer, 1)
+	every <- el.RunEvery(5*time.Millisecond, func() {
+		ticks++
+		if ticks == 3 {
+			(<-every).Stop()
+		}
+	})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timer not fired")
+	}
+	el.QueueInLoop(func() {
+		assert.Equal(t, []int{1, 3}, order)
+		assert.Equal(t, 3, ticks)
+		assert.Equal(t, 0, len(el.timers))
+	})
+	time.Sleep(20 * time.Millisecond)
 }
@@ -0,0 +1,181 @@
+package eventloop
+
+import (
+	"container/heap"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"goreaction/poller"
+	"sync/atomic"
+	"time"
+)
+
+// Timer loop 中的定时器，回调在 loop 的 goroutine 中执行
+type Timer struct {
+	loop    *EventLoop
+	when    time.Time
+	period  time.Duration
+	f       func()
+	index   int
+	stopped atomic.Bool
+}
+
+// Stop 取消定时器，可在任意 goroutine 中调用，返回 false 表示已经取消过或一次性定时器已触发
+func (t *Timer) Stop() bool {
+	if t.stopped.Swap(true) {
+		return false
+	}
+	t.loop.QueueInLoop(func() {
+		t.loop.removeTimer(t)
+	})
+	return true
+}
+
+// Stopped 是否已取消，一次性定时器触发后也返回 true
+func (t *Timer) Stopped() bool {
+	return t.stopped.Load()
+}
+
+// timerHeap 按到期时间排列的最小堆
+type timerHeap []*Timer
+
+func (h timerHeap) Len() int           { return len(h) }
+func (h timerHeap) Less(i, j int) bool { return h[i].when.Before(h[j].when) }
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*Timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
+
+// timerSocket 以 timerfd 注册在 poller 中，到期时执行 loop 中的定时器
+type timerSocket struct {
+	el *EventLoop
+	fd int
+}
+
+func (ts *timerSocket) HandleEvent(fd int, events poller.Event) {
+	var buf [8]byte
+	_, _ = unix.Read(fd, buf[:])
+	ts.el.runTimers()
+}
+
+func (ts *timerSocket) Close() error {
+	return unix.Close(ts.fd)
+}
+
+// RunAfter d 之后在 loop 中执行 f，可在任意 goroutine 中调用
+func (el *EventLoop) RunAfter(d time.Duration, f func()) *Timer {
+	return el.schedule(d, 0, f)
+}
+
+// RunEvery 每隔 d 在 loop 中执行一次 f，可在任意 goroutine 中调用
+func (el *EventLoop) RunEvery(d time.Duration, f func()) *Timer {
+	return el.schedule(d, d, f)
+}
+
+func (el *EventLoop) schedule(d, period time.Duration, f func()) *Timer {
+	t := &Timer{
+		loop:   el,
+		when:   time.Now().Add(d),
+		period: period,
+		f:      f,
+		index:  -1,
+	}
+	el.QueueInLoop(func() {
+		el.addTimer(t)
+	})
+	return t
+}
+
+func (el *EventLoop) addTimer(t *Timer) {
+	if t.stopped.Load() {
+		return
+	}
+	if el.timer == nil {
+		if err := el.openTimer(); err != nil {
+			el.onError(fmt.Errorf("create timerfd: %w", err))
+			return
+		}
+	}
+	heap.Push(&el.timers, t)
+	if t.index == 0 {
+		el.armTimer()
+	}
+}
+
+func (el *EventLoop) removeTimer(t *Timer) {
+	if t.index < 0 {
+		return
+	}
+	heap.Remove(&el.timers, t.index)
+}
+
+// openTimer 创建 timerfd 并注册，不计入 ConnectionCount
+func (el *EventLoop) openTimer() error {
+	fd, err := unix.TimerfdCreate(unix.CLOCK_MONOTONIC, unix.TFD_NONBLOCK|unix.TFD_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	ts := &timerSocket{el: el, fd: fd}
+	el.sockets[fd] = ts
+	if err := el.poll.AddRead(fd); err != nil {
+		delete(el.sockets, fd)
+		_ = unix.Close(fd)
+		return err
+	}
+	el.timer = ts
+	return nil
+}
+
+// armTimer 按堆顶的到期时间设置 timerfd，堆为空时停止
+func (el *EventLoop) armTimer() {
+	var spec unix.ItimerSpec
+	if len(el.timers) > 0 {
+		d := time.Until(el.timers[0].when)
+		if d <= 0 {
+			// 为 0 会停止 timerfd
+			d = 1
+		}
+		spec.Value = unix.NsecToTimespec(int64(d))
+	}
+	if err := unix.TimerfdSettime(el.timer.fd, 0, &spec, nil); err != nil {
+		el.onError(fmt.Errorf("arm timerfd: %w", err))
+	}
+}
+
+// runTimers 执行所有到期的定时器
+func (el *EventLoop) runTimers() {
+	now := time.Now()
+	for len(el.timers) > 0 && !el.timers[0].when.After(now) {
+		t := heap.Pop(&el.timers).(*Timer)
+		if t.stopped.Load() {
+			continue
+		}
+		if t.period > 0 {
+			t.when = now.Add(t.period)
+			heap.Push(&el.timers, t)
+		} else {
+			t.stopped.Store(true)
+		}
+		t.f()
+	}
+	if el.timer != nil {
+		el.armTimer()
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"goreaction"
+	wsproto "goreaction/plugins/websocket"
 	"goreaction/plugins/websocket/ws"
 	"goreaction/plugins/websocket/ws/utils"
 	"io"
 	"log"
 	"math/rand"
+	"net"
+	"net/http"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -180,3 +184,48 @@ func TestWebSocketServer_CloseConnection(t *testing.T) {
 
 	s.Start()
 }
+
+func TestWebSocketServer_MessageTooBig(t *testing.T) {
+	handler := new(wsExample)
+	u := &ws.Upgrader{}
+	s, err := goreaction.NewServer(wsproto.NewHandlerWrap(u, handler),
+		goreaction.CustomProtocol(wsproto.New(u, wsproto.MaxFrameSize(1024))),
+		goreaction.Address("localhost:2022"),
+		goreaction.NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "localhost:2022")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost:2022\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	// 声明 1MB payload 的 binary 帧，服务端不应等待 payload
+	header := []byte{0x82, 0x80 | 127, 0, 0, 0, 0, 0, 0x10, 0, 0, 1, 2, 3, 4}
+	if _, err := conn.Write(header); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	frame, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// close 帧，状态码 1009
+	assert.Equal(t, []byte{0x88, 0x02, 0x03, 0xF1}, frame)
+}
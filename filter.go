@@ -0,0 +1,78 @@
+package goreaction
+
+import (
+	"golang.org/x/sys/unix"
+	"net"
+	"sync/atomic"
+)
+
+// AcceptFilter accept 后立即调用，返回 false 时直接关闭 fd，不会分配任何连接资源
+type AcceptFilter func(fd int, sa unix.Sockaddr) bool
+
+// CIDRList 不可变的 CIDR 黑白名单，deny 优先；allow 非空时只接受其中的地址
+type CIDRList struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func NewCIDRList(allow, deny []string) (*CIDRList, error) {
+	a, err := parseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	d, err := parseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &CIDRList{allow: a, deny: d}, nil
+}
+
+func (l *CIDRList) Allowed(ip net.IP) bool {
+	for _, n := range l.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(l.allow) == 0 {
+		return true
+	}
+	for _, n := range l.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPFilter 可在运行时原子替换规则的 CIDR 过滤器，Accept 可直接作为 OnAccept 的 hook
+type IPFilter struct {
+	list atomic.Pointer[CIDRList]
+}
+
+func NewIPFilter(l *CIDRList) *IPFilter {
+	f := &IPFilter{}
+	f.Store(l)
+	return f
+}
+
+// Store 替换规则，nil 表示接受所有连接
+func (f *IPFilter) Store(l *CIDRList) {
+	f.list.Store(l)
+}
+
+func (f *IPFilter) Load() *CIDRList {
+	return f.list.Load()
+}
+
+// Accept 非 IP 连接（如 unix socket）总是接受
+func (f *IPFilter) Accept(_ int, sa unix.Sockaddr) bool {
+	l := f.list.Load()
+	if l == nil {
+		return true
+	}
+	ip := sockaddrIP(sa)
+	if ip == nil {
+		return true
+	}
+	return l.Allowed(ip)
+}
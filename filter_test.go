@@ -0,0 +1,81 @@
+package goreaction
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCIDRList(t *testing.T) {
+	l, err := NewCIDRList([]string{"10.0.0.0/8", "::1/128"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, l.Allowed(net.ParseIP("10.0.0.1")))
+	assert.True(t, l.Allowed(net.ParseIP("::1")))
+	assert.False(t, l.Allowed(net.ParseIP("10.1.2.3")))
+	assert.False(t, l.Allowed(net.ParseIP("192.168.0.1")))
+
+	l, _ = NewCIDRList(nil, []string{"192.168.0.0/24"})
+	assert.True(t, l.Allowed(net.ParseIP("192.168.1.1")))
+	assert.False(t, l.Allowed(net.ParseIP("192.168.0.1")))
+
+	_, err = NewCIDRList([]string{"10.0.0.1"}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestIPFilter(t *testing.T) {
+	f := NewIPFilter(nil)
+	sa := &unix.SockaddrInet4{Addr: [4]byte{192, 168, 0, 1}}
+	assert.True(t, f.Accept(0, sa))
+
+	l, _ := NewCIDRList(nil, []string{"192.168.0.0/16"})
+	f.Store(l)
+	assert.False(t, f.Accept(0, sa))
+	assert.True(t, f.Accept(0, &unix.SockaddrUnix{Name: "/tmp/a.sock"}))
+}
+
+func TestServer_OnAccept(t *testing.T) {
+	handler := new(serverTest)
+	deny, _ := NewCIDRList(nil, []string{"127.0.0.0/8"})
+	filter := NewIPFilter(deny)
+
+	s, err := NewServer(handler, Address("127.0.0.1:12362"), OnAccept(filter.Accept))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12362")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := conn.Read(make([]byte, 1))
+	_ = conn.Close()
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, uint64(1), s.FilteredCount())
+	assert.Equal(t, int64(0), handler.Count.Load())
+
+	// 运行时替换规则
+	filter.Store(nil)
+	conn, err = net.Dial("tcp", "127.0.0.1:12362")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ping", string(buf))
+	assert.Equal(t, uint64(1), s.FilteredCount())
+}
@@ -3,7 +3,6 @@ module goreaction
 go 1.20
 
 require (
-	github.com/RussellLuo/timingwheel v0.0.0-20220218152713-54845bda3108
 	github.com/gobwas/httphead v0.1.0
 	github.com/gobwas/pool v0.2.1
 	github.com/stretchr/testify v1.8.4
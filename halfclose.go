@@ -0,0 +1,122 @@
+package goreaction
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+)
+
+var ErrWriteShutdown = errors.New("write side shut down")
+
+// ReadClosedHandler Handler 可选实现，HalfClose 模式下对端关闭写端（读到 EOF）时在 loop 中回调。
+// 之后连接仍可写，调用 Close 时在 outBuf 写完后 SHUT_WR 并关闭
+type ReadClosedHandler interface {
+	OnReadClosed(c *Connection)
+}
+
+// ReadClosed 对端是否已关闭写端，只在 HalfClose 模式下有效
+func (c *Connection) ReadClosed() bool {
+	return c.readClosed.Load()
+}
+
+// WriteShutdown 是否已调用 ShutdownWrite
+func (c *Connection) WriteShutdown() bool {
+	return c.shutWr.Load()
+}
+
+// ShutdownWrite 在已排队的数据写完后关闭写端（SHUT_WR），之后 Send 返回 ErrWriteShutdown。
+// 对端也已关闭写端时关闭连接
+func (c *Connection) ShutdownWrite() error {
+	if !c.connected.Load() {
+		return ErrConnectionClosed
+	}
+	if c.shutWr.Swap(true) {
+		return nil
+	}
+
+	c.loop.QueueInLoop(func() {
+		if !c.connected.Load() {
+			return
+		}
+		if c.writePending() {
+			c.shutWrPending = true
+			return
+		}
+		c.shutdownWriteInLoop()
+	})
+	return nil
+}
+
+// shutdownWriteInLoop 关闭写端，读写两端都已关闭时关闭连接
+func (c *Connection) shutdownWriteInLoop() (closed bool) {
+	c.shutWrPending = false
+	if err := unix.Shutdown(c.fd, unix.SHUT_WR); err != nil {
+		c.reportError(OpWrite, err)
+		c.closeWith(CloseWriteError, err)
+		return true
+	}
+	c.shutWrDone = true
+	if c.readClosed.Load() {
+		c.closeWith(ClosePeerEOF, nil)
+		return true
+	}
+	return false
+}
+
+// handleReadClosed HalfClose 模式下读到 EOF，停止读取并保持连接可写
+func (c *Connection) handleReadClosed() (closed bool) {
+	c.readClosed.Store(true)
+	c.readPending = false
+	if c.shutWrDone {
+		c.closeWith(ClosePeerEOF, nil)
+		return true
+	}
+	if !c.edgeTriggered {
+		// EOF 会持续触发读事件
+		if err := c.updateInterest(); err != nil {
+			c.reportError(OpRead, err)
+			c.closeWith(CloseReadError, err)
+			return true
+		}
+	}
+
+	if c.readClosedHook != nil && c.opened {
+		c.readClosedHook.OnReadClosed(c)
+	}
+	return !c.connected.Load()
+}
+
+// closeAfterFlush outBuf 写完后以 reason 关闭连接，对端已关闭写端时先 SHUT_WR
+func (c *Connection) closeAfterFlush(reason CloseReason) {
+	if !c.connected.Load() {
+		return
+	}
+	c.drainReason = reason
+	if c.drainPending() {
+		c.draining = true
+		return
+	}
+	c.closeDrained()
+}
+
+func (c *Connection) closeDrained() {
+	if c.readClosed.Load() && !c.shutWrDone {
+		_ = unix.Shutdown(c.fd, unix.SHUT_WR)
+	}
+	c.closeWith(c.drainReason, nil)
+}
+
+// outBufDrained outBuf 写完后处理等待中的关闭和 SHUT_WR
+func (c *Connection) outBufDrained() (closed bool) {
+	if c.draining {
+		if c.inflight.Load() > 0 {
+			// 由 workerIdle 关闭
+			return false
+		}
+		c.closeDrained()
+		return true
+	}
+	if c.shutWrPending {
+		return c.shutdownWriteInLoop()
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package goreaction
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type halfCloseExample struct {
+	serverTest
+	reasons chan CloseReason
+}
+
+func (s *halfCloseExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	n, _ := c.Context().(int)
+	c.SetContext(n + len(data))
+	return
+}
+
+func (s *halfCloseExample) OnReadClosed(c *Connection) {
+	n, _ := c.Context().(int)
+	// 足够大以进入 outBuf，验证 Close 会等待写完
+	reply := bytes.Repeat([]byte{'x'}, 4<<20)
+	copy(reply, fmt.Sprintf("%d;", n))
+	if err := c.Send(reply); err != nil {
+		panic(err)
+	}
+	if err := c.Close(); err != nil {
+		panic(err)
+	}
+}
+
+func (s *halfCloseExample) OnClose(c *Connection) {
+	reason, _ := c.CloseReason()
+	s.reasons <- reason
+}
+
+func TestHalfClose(t *testing.T) {
+	for i, opts := range [][]Option{nil, {EdgeTriggered(0)}} {
+		addr := fmt.Sprintf("127.0.0.1:%d", 12376+i)
+		handler := &halfCloseExample{reasons: make(chan CloseReason, 1)}
+		s, err := NewServer(handler, append(opts, Address(addr), NumLoops(1), HalfClose(true))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go s.Start()
+		time.Sleep(100 * time.Millisecond)
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write([]byte("upload")); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		data, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 4<<20 || !bytes.HasPrefix(data, []byte("6;")) {
+			t.Fatalf("reply should be 4MB starting with 6;, but %d bytes", len(data))
+		}
+		select {
+		case reason := <-handler.reasons:
+			if reason != CloseLocal {
+				t.Fatalf("close reason should be %v, but %v", CloseLocal, reason)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("OnClose not called")
+		}
+
+		_ = conn.Close()
+		s.Stop()
+	}
+}
+
+type shutdownWriteExample struct {
+	serverTest
+	errs chan error
+}
+
+func (s *shutdownWriteExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	_ = c.Send([]byte("bye"))
+	_ = c.ShutdownWrite()
+	s.errs <- c.Send([]byte("more"))
+	return
+}
+
+func TestConnShutdownWrite(t *testing.T) {
+	handler := &shutdownWriteExample{errs: make(chan error, 1)}
+	s, err := NewServer(handler, Address("127.0.0.1:12378"), NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12378")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	data, err := io.ReadAll(conn)
+	if err != nil || string(data) != "bye" {
+		t.Fatalf("read should be bye then EOF, but %q %v", data, err)
+	}
+	if err := <-handler.errs; err != ErrWriteShutdown {
+		t.Fatalf("send after ShutdownWrite should be ErrWriteShutdown, but %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package goreaction
+
+import (
+	"math"
+	"time"
+)
+
+// IdleKind 空闲的类型
+type IdleKind int
+
+const (
+	// IdleRead 超过 ReaderIdleTime 没有读到数据
+	IdleRead IdleKind = iota
+	// IdleWrite 超过 WriterIdleTime 没有写出数据
+	IdleWrite
+	// IdleAll 超过 AllIdleTime 既没有读到也没有写出数据
+	IdleAll
+)
+
+func (k IdleKind) String() string {
+	switch k {
+	case IdleRead:
+		return "read idle"
+	case IdleWrite:
+		return "write idle"
+	case IdleAll:
+		return "all idle"
+	}
+	return "unknown"
+}
+
+// IdlePolicy 连接空闲时的处理方式
+type IdlePolicy int
+
+const (
+	// IdleNotify 只调用 IdleHandler.OnIdle，Handler 未实现 IdleHandler 时关闭连接
+	IdleNotify IdlePolicy = iota
+	// IdleClose 调用 OnIdle 后以 CloseIdleTimeout 关闭连接
+	IdleClose
+)
+
+func (p IdlePolicy) String() string {
+	switch p {
+	case IdleNotify:
+		return "notify"
+	case IdleClose:
+		return "close"
+	}
+	return "unknown"
+}
+
+// IdleHandler 由 Handler 实现，连接空闲时在 loop 中调用，可在其中发送心跳。
+// 连接保持空闲时每隔对应的时长再次调用
+type IdleHandler interface {
+	OnIdle(c *Connection, kind IdleKind)
+}
+
+// idleState 按 IdleKind 索引的空闲时长和上次触发的时间，单位为毫秒，只在 loop 中访问
+type idleState struct {
+	limits [3]int64
+	fired  [3]int64
+	close  bool
+}
+
+func newIdleState(opts *Options) *idleState {
+	if opts.ReaderIdleTime <= 0 && opts.WriterIdleTime <= 0 && opts.AllIdleTime <= 0 {
+		return nil
+	}
+	s := &idleState{close: opts.IdlePolicy == IdleClose}
+	for i, d := range []time.Duration{opts.ReaderIdleTime, opts.WriterIdleTime, opts.AllIdleTime} {
+		if d > 0 {
+			// 不足 1 毫秒按 1 毫秒计
+			s.limits[i] = int64((d + time.Millisecond - 1) / time.Millisecond)
+		}
+	}
+	return s
+}
+
+// LastRead 最后一次读到数据的时间，未开启空闲检测时为零值
+func (c *Connection) LastRead() time.Time {
+	if c.idle == nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(c.lastRead.Load())
+}
+
+// LastWrite 最后一次写出数据的时间，未开启空闲检测时为零值
+func (c *Connection) LastWrite() time.Time {
+	if c.idle == nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(c.lastWrite.Load())
+}
+
+func (c *Connection) touchRead() {
+	if c.idle != nil {
+		c.lastRead.Store(time.Now().UnixMilli())
+	}
+}
+
+func (c *Connection) touchWrite() {
+	if c.idle != nil {
+		c.lastWrite.Store(time.Now().UnixMilli())
+	}
+}
+
+// startIdle 开始空闲检测
+func (c *Connection) startIdle() {
+	now := time.Now().UnixMilli()
+	c.lastRead.Store(now)
+	c.lastWrite.Store(now)
+	c.timer.Store(c.loop.RunAfter(time.Duration(c.nextIdle(now)-now)*time.Millisecond, c.checkIdle))
+}
+
+// idleSince kind 对应的最后活动时间，暂停读取的连接不算读空闲
+func (c *Connection) idleSince(kind IdleKind, now int64) int64 {
+	read, write := c.lastRead.Load(), c.lastWrite.Load()
+	if c.readPaused.Load() {
+		read = now
+	}
+	switch kind {
+	case IdleRead:
+		return read
+	case IdleWrite:
+		return write
+	}
+	if read > write {
+		return read
+	}
+	return write
+}
+
+// idleDue kind 下一次空闲的时间，触发后需再空闲一个时长才会再次触发
+func (c *Connection) idleDue(kind IdleKind, now int64) int64 {
+	last := c.idleSince(kind, now)
+	if fired := c.idle.fired[kind]; fired > last {
+		last = fired
+	}
+	return last + c.idle.limits[kind]
+}
+
+func (c *Connection) nextIdle(now int64) int64 {
+	next := int64(math.MaxInt64)
+	for k, limit := range c.idle.limits {
+		if limit <= 0 {
+			continue
+		}
+		if due := c.idleDue(IdleKind(k), now); due < next {
+			next = due
+		}
+	}
+	return next
+}
+
+// checkIdle 在 loop 中检查各类空闲并重新设置定时器
+func (c *Connection) checkIdle() {
+	if !c.connected.Load() {
+		return
+	}
+	now := time.Now().UnixMilli()
+	for k, limit := range c.idle.limits {
+		if limit <= 0 || c.idleDue(IdleKind(k), now) > now {
+			continue
+		}
+		c.idle.fired[k] = now
+		if c.fireIdle(IdleKind(k)) {
+			return
+		}
+	}
+	c.timer.Store(c.loop.RunAfter(time.Duration(c.nextIdle(now)-now)*time.Millisecond, c.checkIdle))
+}
+
+func (c *Connection) fireIdle(kind IdleKind) (closed bool) {
+	if c.idleHook != nil && c.opened {
+		c.idleHook.OnIdle(c, kind)
+	}
+	if c.idle.close || c.idleHook == nil {
+		c.closeWith(CloseIdleTimeout, nil)
+	}
+	return !c.connected.Load()
+}
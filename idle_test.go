@@ -0,0 +1,106 @@
+package goreaction
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type idleExample struct {
+	serverTest
+	kinds  chan IdleKind
+	closed chan CloseReason
+}
+
+func (h *idleExample) OnIdle(c *Connection, kind IdleKind) {
+	h.kinds <- kind
+	if kind == IdleWrite {
+		_ = c.Send([]byte("ping"))
+	}
+}
+
+func (h *idleExample) OnClose(c *Connection) {
+	reason, _ := c.CloseReason()
+	h.closed <- reason
+}
+
+func TestConnIdleState(t *testing.T) {
+	for i, policy := range []IdlePolicy{IdleNotify, IdleClose} {
+		addr := "127.0.0.1:" + []string{"12388", "12389"}[i]
+		handler := &idleExample{kinds: make(chan IdleKind, 64), closed: make(chan CloseReason, 1)}
+		s, err := NewServer(handler, Address(addr), NumLoops(1),
+			IdleState(250*time.Millisecond, 150*time.Millisecond, 400*time.Millisecond, policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go s.Start()
+		time.Sleep(100 * time.Millisecond)
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+
+		select {
+		case kind := <-handler.kinds:
+			if kind != IdleWrite {
+				t.Fatalf("kind should be %v, but %v", IdleWrite, kind)
+			}
+			if d := time.Since(start); d < 140*time.Millisecond {
+				t.Fatalf("idle fired too early: %v", d)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("OnIdle not called")
+		}
+
+		if policy == IdleClose {
+			select {
+			case reason := <-handler.closed:
+				if reason != CloseIdleTimeout {
+					t.Fatalf("reason should be %v, but %v", CloseIdleTimeout, reason)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("idle connection should be closed")
+			}
+			_ = conn.Close()
+			s.Stop()
+			continue
+		}
+
+		// 心跳不断重置写空闲，读空闲重复触发，读写都空闲不会触发
+		buf := make([]byte, 4)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("heartbeat should be received, but %q %v", buf, err)
+		}
+		time.Sleep(700 * time.Millisecond)
+		counts := make(map[IdleKind]int)
+		for len(handler.kinds) > 0 {
+			counts[<-handler.kinds]++
+		}
+		if counts[IdleRead] < 2 || counts[IdleWrite] < 2 || counts[IdleAll] != 0 {
+			t.Fatalf("unexpected idle events: %v", counts)
+		}
+		select {
+		case reason := <-handler.closed:
+			t.Fatalf("connection should not be closed, but %v", reason)
+		default:
+		}
+
+		_ = conn.Close()
+		s.Stop()
+	}
+}
+
+func TestIdleTimeWithIdleState(t *testing.T) {
+	opts := newOptions(IdleTime(time.Second), IdleState(time.Second, 0, 0, IdleNotify))
+	if opts.AllIdleTime != 0 || opts.IdlePolicy != IdleNotify {
+		t.Fatalf("IdleTime should not override IdleState, but %v %v", opts.AllIdleTime, opts.IdlePolicy)
+	}
+	opts = newOptions(IdleTime(time.Second))
+	if opts.AllIdleTime != time.Second || opts.IdlePolicy != IdleClose {
+		t.Fatalf("IdleTime should close after all idle, but %v %v", opts.AllIdleTime, opts.IdlePolicy)
+	}
+}
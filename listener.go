@@ -2,31 +2,142 @@ package goreaction
 
 import (
 	"errors"
+	"fmt"
 	"golang.org/x/sys/unix"
 	"goreaction/eventloop"
 	"goreaction/poller"
 	"goreaction/utils/reuseport"
-	"log"
 	"net"
 	"os"
+	"sync/atomic"
+	"time"
 )
 
 type handleConnFunc func(fd int, sa unix.Sockaddr)
 
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+	// completionAccepts poller 支持 Completion 时每个监听 socket 同时提交的 accept 数，一轮可接受多个连接
+	completionAccepts = 8
+)
+
 type listener struct {
 	file     *os.File
 	fd       int
 	handleC  handleConnFunc
 	listener net.Listener
 	loop     *eventloop.EventLoop
+	onError  ErrorHandler
+	backoff  time.Duration
+	filter   AcceptFilter
+	filtered atomic.Uint64
+
+	completion poller.Completion
+	acceptDone func(nfd int, sa unix.Sockaddr, err error)
 }
 
-func newListener(network, addr string, reusePort bool, handlerConn handleConnFunc) (*listener, error) {
+func newListener(opts *Options, handlerConn handleConnFunc) (*listener, error) {
+	ls, file, fd, err := listenSocket(opts, opts.Address, opts.ReusePort)
+	if err != nil {
+		return nil, err
+	}
+
+	loop, err := eventloop.NewWithBackend(opts.Poller)
+
+	if err != nil {
+		_ = file.Close()
+		_ = ls.Close()
+		return nil, err
+	}
+
+	listener := &listener{
+		file:     file,
+		fd:       fd,
+		handleC:  handlerConn,
+		listener: ls,
+		loop:     loop,
+		onError:  opts.ErrorHandler,
+		filter:   opts.AcceptFilter,
+	}
+	loop.SetErrorHandler(func(err error) {
+		listener.onError(&OpError{Op: OpLoop, Err: err})
+	})
+	if err = loop.AddSocketAndEnableRead(listener.fd, listener); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	if err = listener.startAccept(); err != nil {
+		_ = loop.DeleteFdInLoop(listener.fd)
+		_ = listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// newReusePortListeners 为每个 loop 创建一个 SO_REUSEPORT 监听 socket，由内核在各 loop 间分配连接，
+// loop 自己 accept 并处理新连接。listener 需在 loop 运行前创建
+func newReusePortListeners(opts *Options, loops []*eventloop.EventLoop, handlerConn func(loop *eventloop.EventLoop) handleConnFunc) ([]*listener, error) {
+	if opts.Network == "unix" {
+		return nil, errors.New("multi acceptor is not supported on unix socket")
+	}
+
+	listeners := make([]*listener, 0, len(loops))
+	closeAll := func() {
+		for _, l := range listeners {
+			_ = l.loop.RemoveListener(l.fd)
+			_ = l.Close()
+		}
+	}
+
+	addr := opts.Address
+	for _, loop := range loops {
+		ls, file, fd, err := listenSocket(opts, addr, true)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		// 端口为 0 时其余 socket 使用第一个 socket 分配到的端口
+		addr = ls.Addr().String()
+
+		l := &listener{
+			file:     file,
+			fd:       fd,
+			handleC:  handlerConn(loop),
+			listener: ls,
+			loop:     loop,
+			onError:  opts.ErrorHandler,
+			filter:   opts.AcceptFilter,
+		}
+		if err = loop.AddListener(l.fd, l); err != nil {
+			_ = l.Close()
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, l)
+		if err = l.startAccept(); err != nil {
+			closeAll()
+			return nil, err
+		}
+	}
+	return listeners, nil
+}
+
+// listenSocket 创建监听 socket，返回非阻塞的 fd
+func listenSocket(opts *Options, addr string, reusePort bool) (net.Listener, *os.File, int, error) {
 	var (
 		ls  net.Listener
 		err error
 	)
-	if reusePort {
+	network := opts.Network
+	isUnix := network == "unix"
+	if isUnix {
+		if err = removeStaleUnixSocket(addr); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	if reusePort && !isUnix {
 		//reusePortCfg := net.ListenConfig{
 		//	Control: func(network, address string, c syscall.RawConn) error {
 		//		return c.Control(func(fd uintptr) {
@@ -38,47 +149,63 @@ func newListener(network, addr string, reusePort bool, handlerConn handleConnFun
 		//ls, err = reusePortCfg.Listen(nil, network, addr)
 		ls, err = reuseport.Listen(network, addr)
 		if err != nil {
-			return nil, err
+			return nil, nil, 0, err
 		}
 	} else {
 		ls, err = net.Listen(network, addr)
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
 
-	l, ok := ls.(*net.TCPListener)
-	if !ok {
-		return nil, errors.New("could not get file descriptor")
+	var file *os.File
+	switch l := ls.(type) {
+	case *net.TCPListener:
+		file, err = l.File()
+	case *net.UnixListener:
+		if opts.UnixSocketPerm != 0 {
+			if err = os.Chmod(addr, opts.UnixSocketPerm); err != nil {
+				_ = ls.Close()
+				return nil, nil, 0, err
+			}
+		}
+		file, err = l.File()
+	default:
+		_ = ls.Close()
+		return nil, nil, 0, errors.New("could not get file descriptor")
 	}
-
-	file, err := l.File()
 	if err != nil {
-		return nil, err
+		_ = ls.Close()
+		return nil, nil, 0, err
 	}
 	fd := int(file.Fd())
 	if err = unix.SetNonblock(fd, true); err != nil {
-		return nil, err
+		_ = file.Close()
+		_ = ls.Close()
+		return nil, nil, 0, err
 	}
+	return ls, file, fd, nil
+}
 
-	loop, err := eventloop.New()
-
+// removeStaleUnixSocket 删除上次进程遗留的 socket 文件，文件仍有进程在监听时保留
+func removeStaleUnixSocket(path string) error {
+	fi, err := os.Stat(path)
 	if err != nil {
-		return nil, err
-	}
-
-	listener := &listener{
-		file:     file,
-		fd:       fd,
-		handleC:  handlerConn,
-		listener: ls,
-		loop:     loop,
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
 	}
-	if err = loop.AddSocketAndEnableRead(fd, listener); err != nil {
-		return nil, err
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
 	}
 
-	return listener, nil
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+	return os.Remove(path)
 }
 
 func (l *listener) Run() {
@@ -90,13 +217,22 @@ func (l *listener) HandleEvent(fd int, events poller.Event) {
 		nfd, sa, err := unix.Accept(fd)
 		if err != nil {
 			if err != unix.EAGAIN {
-				log.Fatal("accept:", err)
+				l.onError(&OpError{Op: OpAccept, Err: err})
+				if isTemporaryAcceptErr(err) {
+					l.pauseAccept(fd)
+				}
 			}
 			return
 		}
+		l.backoff = 0
+		if l.filter != nil && !l.filter(nfd, sa) {
+			_ = unix.Close(nfd)
+			l.filtered.Add(1)
+			return
+		}
 		if err := unix.SetNonblock(nfd, true); err != nil {
-			unix.Close(nfd)
-			log.Fatal("set nonblock:", err)
+			_ = unix.Close(nfd)
+			l.onError(&OpError{Op: OpAccept, Err: err})
 			return
 		}
 
@@ -104,7 +240,98 @@ func (l *listener) HandleEvent(fd int, events poller.Event) {
 	}
 }
 
+// startAccept poller 支持 Completion 时不再关注读事件，改为提交 accept 请求，需在 loop 运行前调用
+func (l *listener) startAccept() error {
+	l.completion = l.loop.Completion()
+	if l.completion == nil {
+		return nil
+	}
+	l.acceptDone = l.handleAccept
+	if err := l.loop.DisableRead(l.fd); err != nil {
+		return err
+	}
+	for i := 0; i < completionAccepts; i++ {
+		if err := l.completion.Accept(l.fd, l.acceptDone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acceptCompletion 提交一个 accept 请求，监听 socket 已移除时不再提交
+func (l *listener) acceptCompletion() {
+	if err := l.completion.Accept(l.fd, l.acceptDone); err != nil && err != unix.ENOENT {
+		l.onError(&OpError{Op: OpAccept, Err: err})
+	}
+}
+
+// handleAccept accept 请求完成，与 HandleEvent 相同地处理新连接后再次提交
+func (l *listener) handleAccept(nfd int, sa unix.Sockaddr, err error) {
+	if err != nil {
+		if err == unix.ECANCELED {
+			// 监听 socket 已移除
+			return
+		}
+		l.onError(&OpError{Op: OpAccept, Err: err})
+		if isTemporaryAcceptErr(err) {
+			l.pauseAccept(l.fd)
+			return
+		}
+		l.acceptCompletion()
+		return
+	}
+
+	l.backoff = 0
+	if l.filter != nil && !l.filter(nfd, sa) {
+		_ = unix.Close(nfd)
+		l.filtered.Add(1)
+	} else {
+		l.handleC(nfd, sa)
+	}
+	l.acceptCompletion()
+}
+
+// isTemporaryAcceptErr 资源耗尽类错误，短时间内重试仍会失败
+func isTemporaryAcceptErr(err error) bool {
+	switch err {
+	case unix.EMFILE, unix.ENFILE, unix.ENOBUFS, unix.ENOMEM:
+		return true
+	}
+	return false
+}
+
+// pauseAccept 暂停监听 fd 的读事件，退避一段时间后恢复。以完成事件 accept 时退避后再提交
+func (l *listener) pauseAccept(fd int) {
+	if l.backoff == 0 {
+		l.backoff = minAcceptBackoff
+	} else if l.backoff *= 2; l.backoff > maxAcceptBackoff {
+		l.backoff = maxAcceptBackoff
+	}
+	if l.completion != nil {
+		time.AfterFunc(l.backoff, func() {
+			l.loop.QueueInLoop(l.acceptCompletion)
+		})
+		return
+	}
+	if err := l.loop.DisableRead(fd); err != nil {
+		l.onError(&OpError{Op: OpAccept, Err: err})
+		return
+	}
+
+	time.AfterFunc(l.backoff, func() {
+		l.loop.QueueInLoop(func() {
+			if err := l.loop.EnableRead(fd); err != nil {
+				l.onError(&OpError{Op: OpAccept, Err: err})
+			}
+		})
+	})
+}
+
 func (l *listener) Close() error {
+	if err := l.file.Close(); err != nil {
+		_ = l.listener.Close()
+		return err
+	}
 	return l.listener.Close()
 }
 
@@ -0,0 +1,86 @@
+package goreaction
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type unixExample struct {
+	serverTest
+	cred chan int
+}
+
+func (s *unixExample) OnConnect(c *Connection) {
+	if cred := c.PeerCred(); cred != nil {
+		s.cred <- int(cred.Uid)
+	} else {
+		s.cred <- -1
+	}
+}
+
+func TestUnixListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goreaction.sock")
+
+	// 遗留的 socket 文件
+	ls, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = ls.Close()
+
+	handler := &unixExample{cred: make(chan int, 1)}
+	s, err := NewServer(handler, Network("unix"), Address(path), UnixSocketPerm(0600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("perm should be 0600, but %v", fi.Mode().Perm())
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if uid := <-handler.cred; uid != os.Getuid() {
+		t.Fatalf("uid should be %d, but %d", os.Getuid(), uid)
+	}
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatal(string(buf))
+	}
+}
+
+func TestRemoveStaleUnixSocketInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goreaction.sock")
+	ls, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	if err := removeStaleUnixSocket(path); err == nil {
+		t.Fatal("socket in use should not be removed")
+	}
+}
@@ -0,0 +1,65 @@
+package goreaction
+
+import (
+	"golang.org/x/sys/unix"
+	"goreaction/eventloop"
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// LoadBalancer 为新连接选择 work loop
+type LoadBalancer interface {
+	Next(loops []*eventloop.EventLoop, sa unix.Sockaddr) *eventloop.EventLoop
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// RoundRobin 轮询分配
+func RoundRobin() LoadBalancer {
+	return &roundRobin{}
+}
+
+func (r *roundRobin) Next(loops []*eventloop.EventLoop, _ unix.Sockaddr) *eventloop.EventLoop {
+	idx := r.next.Add(1) - 1
+	return loops[idx%uint64(len(loops))]
+}
+
+type leastConnections struct{}
+
+// LeastConnections 分配给当前连接数最少的 loop
+func LeastConnections() LoadBalancer {
+	return leastConnections{}
+}
+
+func (leastConnections) Next(loops []*eventloop.EventLoop, _ unix.Sockaddr) *eventloop.EventLoop {
+	l := loops[0]
+	min := l.ConnectionCount()
+	for i := 1; i < len(loops); i++ {
+		if n := loops[i].ConnectionCount(); n < min {
+			l, min = loops[i], n
+		}
+	}
+	return l
+}
+
+type sourceAddrHash struct{}
+
+// SourceAddrHash 按客户端 IP 哈希分配，同一 IP 总是落在同一个 loop
+func SourceAddrHash() LoadBalancer {
+	return sourceAddrHash{}
+}
+
+func (sourceAddrHash) Next(loops []*eventloop.EventLoop, sa unix.Sockaddr) *eventloop.EventLoop {
+	h := fnv.New32a()
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		_, _ = h.Write(sa.Addr[:])
+	case *unix.SockaddrInet6:
+		_, _ = h.Write(sa.Addr[:])
+	default:
+		_, _ = h.Write([]byte(sockAddrToString(sa)))
+	}
+	return loops[h.Sum32()%uint32(len(loops))]
+}
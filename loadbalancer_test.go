@@ -0,0 +1,102 @@
+package goreaction
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+	"goreaction/eventloop"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestLoops(t *testing.T, n int) []*eventloop.EventLoop {
+	loops := make([]*eventloop.EventLoop, n)
+	for i := range loops {
+		l, err := eventloop.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		loops[i] = l
+	}
+	return loops
+}
+
+func distribution(lb LoadBalancer, loops []*eventloop.EventLoop, addrs []unix.Sockaddr) map[*eventloop.EventLoop]int {
+	dist := make(map[*eventloop.EventLoop]int)
+	for _, sa := range addrs {
+		dist[lb.Next(loops, sa)]++
+	}
+	return dist
+}
+
+func TestRoundRobin(t *testing.T) {
+	loops := newTestLoops(t, 4)
+	addrs := make([]unix.Sockaddr, 100)
+	for i := range addrs {
+		addrs[i] = &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}, Port: 10000 + i}
+	}
+
+	dist := distribution(RoundRobin(), loops, addrs)
+	assert.Equal(t, 4, len(dist))
+	for _, l := range loops {
+		assert.Equal(t, 25, dist[l])
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	loops := newTestLoops(t, 4)
+	loops[0].ConnCnt.Add(3)
+	loops[1].ConnCnt.Add(1)
+	loops[2].ConnCnt.Add(2)
+	loops[3].ConnCnt.Add(1)
+
+	lb := LeastConnections()
+	for i := 0; i < 5; i++ {
+		l := lb.Next(loops, nil)
+		l.ConnCnt.Add(1)
+	}
+	for _, l := range loops {
+		assert.Equal(t, int64(3), l.ConnectionCount())
+	}
+}
+
+func TestSourceAddrHash(t *testing.T) {
+	loops := newTestLoops(t, 4)
+	lb := SourceAddrHash()
+
+	first := lb.Next(loops, &unix.SockaddrInet4{Addr: [4]byte{10, 0, 0, 1}, Port: 1000})
+	for port := 1001; port < 1100; port++ {
+		assert.Equal(t, first, lb.Next(loops, &unix.SockaddrInet4{Addr: [4]byte{10, 0, 0, 1}, Port: port}))
+	}
+
+	addrs := make([]unix.Sockaddr, 256)
+	for i := range addrs {
+		addrs[i] = &unix.SockaddrInet4{Addr: [4]byte{10, 0, 1, byte(i)}, Port: 1000}
+	}
+	assert.Equal(t, 4, len(distribution(lb, loops, addrs)))
+}
+
+func TestServer_LoadBalance(t *testing.T) {
+	handler := new(serverTest)
+
+	s, err := NewServer(handler, Address("127.0.0.1:12348"), NumLoops(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 8; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:12348")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for _, l := range s.workLoops {
+		assert.Equal(t, int64(2), l.ConnectionCount())
+	}
+}
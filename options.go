@@ -1,14 +1,63 @@
 package goreaction
 
-import "time"
+import (
+	"crypto/tls"
+	"goreaction/poller"
+	"os"
+	"time"
+)
 
 type Options struct {
 	Network   string
 	Address   string
 	NumLoops  int
 	ReusePort bool
-	IdleTime  time.Duration
-	Protocol  Protocol
+	// MultiAcceptor 每个 work loop 各自监听并 accept，不使用主 reactor
+	MultiAcceptor bool
+	// IdleTime 等同于 IdleState(0, 0, IdleTime, IdleClose)，设置了 IdleState 时不生效
+	IdleTime time.Duration
+	Protocol Protocol
+
+	// ReaderIdleTime、WriterIdleTime、AllIdleTime 大于 0 时检测对应的空闲，见 IdleHandler
+	ReaderIdleTime time.Duration
+	WriterIdleTime time.Duration
+	AllIdleTime    time.Duration
+	IdlePolicy     IdlePolicy
+
+	UnixSocketPerm os.FileMode
+	TLSConfig      *tls.Config
+	// TLSHandshakeTimeout 为 0 时使用 DefaultTLSHandshakeTimeout
+	TLSHandshakeTimeout time.Duration
+
+	ProxyProtocol  bool
+	TrustedProxies []string
+
+	MaxConnections      int
+	MaxConnectionsPerIP int
+	RejectHandler       func(addr string, reason RejectReason)
+	RejectPayload       []byte
+
+	AcceptFilter AcceptFilter
+
+	MaxReadBufferSize int
+	// HalfClose 对端关闭写端后保持连接可写，见 ReadClosedHandler
+	HalfClose bool
+
+	WriteBufferHighWater int
+	WriteBufferLowWater  int
+	WriteBufferHardCap   int
+
+	Poller              poller.Backend
+	EdgeTriggered       bool
+	EdgeTriggeredBudget int
+
+	// Workers 大于 0 时 OnMessage 在 worker goroutine 中异步执行，见 AsyncHandler
+	Workers           int
+	WorkerQueueSize   int
+	WorkerQueuePolicy QueuePolicy
+
+	LoadBalancer LoadBalancer
+	ErrorHandler ErrorHandler
 
 	tick      time.Duration
 	wheelSize int64
@@ -29,15 +78,34 @@ func newOptions(opt ...Option) *Options {
 	if opts.Address == "" {
 		opts.Address = ":12345"
 	}
+	if opts.IdleTime > 0 && opts.ReaderIdleTime <= 0 && opts.WriterIdleTime <= 0 && opts.AllIdleTime <= 0 {
+		opts.AllIdleTime = opts.IdleTime
+		opts.IdlePolicy = IdleClose
+	}
 	if opts.tick == 0 {
 		opts.tick = 1 * time.Millisecond
 	}
 	if opts.wheelSize == 0 {
 		opts.wheelSize = 1000
 	}
+	if opts.TLSConfig != nil && opts.TLSHandshakeTimeout <= 0 {
+		opts.TLSHandshakeTimeout = DefaultTLSHandshakeTimeout
+	}
 	if opts.Protocol == nil {
 		opts.Protocol = &DefaultProtocol{}
 	}
+	if opts.EdgeTriggered && opts.EdgeTriggeredBudget <= 0 {
+		opts.EdgeTriggeredBudget = DefaultEdgeTriggeredBudget
+	}
+	if opts.Workers > 0 && opts.WorkerQueueSize <= 0 {
+		opts.WorkerQueueSize = DefaultWorkerQueueSize
+	}
+	if opts.LoadBalancer == nil {
+		opts.LoadBalancer = RoundRobin()
+	}
+	if opts.ErrorHandler == nil {
+		opts.ErrorHandler = defaultErrorHandler
+	}
 
 	return &opts
 }
@@ -49,7 +117,16 @@ func ReusePort(reusePort bool) Option {
 	}
 }
 
-// Network [tcp] 暂时只支持tcp
+// MultiAcceptor 每个 work loop 持有一个 SO_REUSEPORT 监听 socket 并直接 accept，
+// 由内核在 loop 间分配连接，省去主 reactor 到 work loop 的转交。
+// 开启后 LoadBalance 对入站连接不生效，OnAccept 会在多个 loop 中并发调用，不支持 unix socket
+func MultiAcceptor(enable bool) Option {
+	return func(o *Options) {
+		o.MultiAcceptor = enable
+	}
+}
+
+// Network [tcp|unix]，unix 时 Address 为 socket 文件路径
 func Network(n string) Option {
 	return func(o *Options) {
 		o.Network = n
@@ -70,15 +147,173 @@ func NumLoops(n int) Option {
 	}
 }
 
-// IdleTime 最大空闲时间（秒）
+// IdleTime 最大空闲时间，超过后关闭连接
 func IdleTime(t time.Duration) Option {
 	return func(o *Options) {
 		o.IdleTime = t
 	}
 }
 
+// IdleState 分别设置读空闲、写空闲和读写都空闲的时长，为 0 的不检测，精度为毫秒。
+// 空闲时调用 IdleHandler.OnIdle，policy 为 IdleClose 时随后关闭连接
+func IdleState(reader, writer, all time.Duration, policy IdlePolicy) Option {
+	return func(o *Options) {
+		o.ReaderIdleTime = reader
+		o.WriterIdleTime = writer
+		o.AllIdleTime = all
+		o.IdlePolicy = policy
+	}
+}
+
 func CustomProtocol(p Protocol) Option {
 	return func(o *Options) {
 		o.Protocol = p
 	}
 }
+
+// LoadBalance 新连接分配 work loop 的策略，默认 RoundRobin
+func LoadBalance(lb LoadBalancer) Option {
+	return func(o *Options) {
+		o.LoadBalancer = lb
+	}
+}
+
+// OnError 反应堆内部错误回调，默认打印日志
+func OnError(h ErrorHandler) Option {
+	return func(o *Options) {
+		o.ErrorHandler = h
+	}
+}
+
+// UnixSocketPerm unix socket 文件权限，0 表示不修改
+func UnixSocketPerm(perm os.FileMode) Option {
+	return func(o *Options) {
+		o.UnixSocketPerm = perm
+	}
+}
+
+// TLSConfig 开启 TLS，握手完成后才回调 OnConnect。
+// crypto/tls 的握手是同步的，每个握手中的连接占用一个 goroutine，超时见 TLSHandshakeTimeout；
+// 握手完成后加解密在连接所属的 loop 中完成
+func TLSConfig(config *tls.Config) Option {
+	return func(o *Options) {
+		o.TLSConfig = config
+	}
+}
+
+// TLSHandshakeTimeout 从开始握手到握手完成的最长时间，超时以 CloseProtocolError 关闭连接
+func TLSHandshakeTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.TLSHandshakeTimeout = d
+	}
+}
+
+// ProxyProtocol 解析 PROXY protocol v1/v2 头并以其中的地址作为 PeerAddr/LocalAddr，
+// 解析完成后才回调 OnConnect。trusted 为可信代理的 CIDR，来源不在其中的连接被拒绝，为空时只信任 loopback
+func ProxyProtocol(trusted ...string) Option {
+	return func(o *Options) {
+		o.ProxyProtocol = true
+		o.TrustedProxies = trusted
+	}
+}
+
+// MaxConnections 最大连接数，0 表示不限制
+func MaxConnections(n int) Option {
+	return func(o *Options) {
+		o.MaxConnections = n
+	}
+}
+
+// MaxConnectionsPerIP 单个客户端 IP 的最大连接数，0 表示不限制。
+// 开启 ProxyProtocol 时按 PROXY 头中的来源计算，头解析完成前不计入
+func MaxConnectionsPerIP(n int) Option {
+	return func(o *Options) {
+		o.MaxConnectionsPerIP = n
+	}
+}
+
+// OnReject 连接因超出限制被拒绝时回调，在 accept 所在的 goroutine 中执行
+func OnReject(f func(addr string, reason RejectReason)) Option {
+	return func(o *Options) {
+		o.RejectHandler = f
+	}
+}
+
+// RejectPayload 拒绝连接时在关闭前写给客户端的内容
+func RejectPayload(payload []byte) Option {
+	return func(o *Options) {
+		o.RejectPayload = payload
+	}
+}
+
+// OnAccept accept 后、分配连接资源前的过滤 hook，返回 false 拒绝连接
+func OnAccept(f AcceptFilter) Option {
+	return func(o *Options) {
+		o.AcceptFilter = f
+	}
+}
+
+// PollerBackend loop 使用的 poller 后端，默认 epoll。
+// poller.BackendIOUring 在内核不支持时退回 epoll。内核支持 IORING_FEAT_FAST_POLL 时，
+// accept、recv、sendmsg 以 io_uring 请求提交，一轮 loop 中的请求与等待合并为一次 io_uring_enter；
+// 此时 SendFile、Relay 分别返回 ErrSendFileCompletion、ErrRelayCompletion。不支持 EdgeTriggered
+func PollerBackend(b poller.Backend) Option {
+	return func(o *Options) {
+		o.Poller = b
+	}
+}
+
+// EdgeTriggered 入站连接以 EPOLLET|EPOLLRDHUP 注册，每次事件读写直到 EAGAIN。
+// budget 为每个连接一轮最多读取的次数，超出后让出 loop 给其他连接，<= 0 时使用 DefaultEdgeTriggeredBudget。
+// 只支持 epoll，poller 不支持时 NewServer 返回 ErrEdgeTriggeredUnsupported
+func EdgeTriggered(budget int) Option {
+	return func(o *Options) {
+		o.EdgeTriggered = true
+		o.EdgeTriggeredBudget = budget
+	}
+}
+
+// WriteBufferWaterMark 待写数据的高低水位（字节），达到 high 时 Send 返回 ErrWouldBlock
+// 并回调 WriteBufferHandler.OnWriteBufferHigh，回落到 low 时回调 OnWriteBufferLow。high 为 0 表示不限制
+func WriteBufferWaterMark(low, high int) Option {
+	return func(o *Options) {
+		o.WriteBufferLowWater = low
+		o.WriteBufferHighWater = high
+	}
+}
+
+// HalfClose 对端 shutdown(SHUT_WR) 后不关闭连接，回调 ReadClosedHandler.OnReadClosed，
+// 连接保持可写直到调用 Close。TLS 连接不支持
+func HalfClose(enable bool) Option {
+	return func(o *Options) {
+		o.HalfClose = enable
+	}
+}
+
+// AsyncHandler 把解码后的消息交给 workers 个 goroutine 调用 OnMessage，不阻塞 loop。
+// 同一连接固定由一个 worker 按顺序处理，OnMessage 的返回值经 QueueInLoop 写回。
+// queueSize 为每个 worker 的队列长度，<= 0 时使用 DefaultWorkerQueueSize，队列满时按 policy 处理。
+// 异步模式下 OnMessage 中只能调用 Send、Close 等可在任意 goroutine 中调用的方法，ctx 不会被复制。
+// OnClose 在该连接已分发的 OnMessage 都返回后回调，Stop、Shutdown 也会等待这些消息处理完
+func AsyncHandler(workers, queueSize int, policy QueuePolicy) Option {
+	return func(o *Options) {
+		o.Workers = workers
+		o.WorkerQueueSize = queueSize
+		o.WorkerQueuePolicy = policy
+	}
+}
+
+// WriteBufferHardCap 待写数据超过 n 字节时关闭连接，0 表示不限制
+func WriteBufferHardCap(n int) Option {
+	return func(o *Options) {
+		o.WriteBufferHardCap = n
+	}
+}
+
+// MaxReadBufferSize Protocol 未取走的数据超过 n 字节时关闭连接并上报 ErrReadBufferFull，0 表示不限制。
+// Protocol 可实现 ReadBufferLimiter 覆盖该值
+func MaxReadBufferSize(n int) Option {
+	return func(o *Options) {
+		o.MaxReadBufferSize = n
+	}
+}
@@ -2,30 +2,63 @@ package websocket
 
 import (
 	"errors"
+	"fmt"
 	"github.com/gobwas/pool/pbytes"
 	"goreaction"
 	"goreaction/plugins/websocket/ws"
 	"goreaction/ringbuffer"
-	"log"
 )
 
 const (
 	upgradedKey     = "gev_ws_upgraded"
 	headerbufferKey = "gev_header_buf"
+	messageLenKey   = "gev_ws_message_len"
+	closingKey      = "gev_ws_closing"
+)
+
+var (
+	ErrFrameTooBig   = errors.New("websocket: frame too big")
+	ErrMessageTooBig = errors.New("websocket: message too big")
 )
 
 // Protocol websocket
 type Protocol struct {
-	upgrade *ws.Upgrader
+	upgrade        *ws.Upgrader
+	maxFrameSize   int64
+	maxMessageSize int64
+}
+
+// Option websocket Protocol 选项
+type Option func(p *Protocol)
+
+// MaxFrameSize 单帧 payload 的最大长度，超过时回复 1009 并关闭连接，0 表示不限制
+func MaxFrameSize(n int64) Option {
+	return func(p *Protocol) {
+		p.maxFrameSize = n
+	}
+}
+
+// MaxMessageSize 分片消息 payload 总长度的最大值，超过时回复 1009 并关闭连接，0 表示不限制
+func MaxMessageSize(n int64) Option {
+	return func(p *Protocol) {
+		p.maxMessageSize = n
+	}
 }
 
 func (p *Protocol) UnPacket(c *goreaction.Connection, buf *ringbuffer.RingBuffer) (ctx interface{}, out []byte) {
+	if _, closing := c.Get(closingKey); closing {
+		// 已回复 1009，丢弃后续数据
+		buf.RetrieveAll()
+		return
+	}
+
 	_, ok := c.Get(upgradedKey)
 	if !ok {
 		var err error
 		out, _, err = p.upgrade.Upgrade(c, buf)
 		if err != nil {
-			log.Fatal("Websocket Upgrade :", err)
+			// out 为握手失败的 HTTP 应答，写出后关闭连接
+			c.CloseWithError(fmt.Errorf("websocket upgrade: %w", err))
 			return
 		}
 		c.Set(upgradedKey, true)
@@ -35,10 +68,16 @@ func (p *Protocol) UnPacket(c *goreaction.Connection, buf *ringbuffer.RingBuffer
 		header, err := ws.VirtualReadHeader(bts.([]byte), buf)
 		if err != nil {
 			if !errors.Is(err, ws.ErrHeaderNotReady) {
-				log.Fatal(err)
+				c.CloseWithError(err)
 			}
 			return
 		}
+		if err = p.checkSize(c, &header); err != nil {
+			buf.VirtualRevert()
+			buf.RetrieveAll()
+			p.closeTooBig(c, err)
+			return
+		}
 		if buf.VirtualLength() >= int(header.Length) {
 			buf.VirtualFlush()
 
@@ -49,6 +88,7 @@ func (p *Protocol) UnPacket(c *goreaction.Connection, buf *ringbuffer.RingBuffer
 				ws.Cipher(payload, header.Mask, 0)
 			}
 
+			p.countMessage(c, &header)
 			ctx = &header
 			out = payload
 		} else {
@@ -62,6 +102,60 @@ func (p *Protocol) Packet(c *goreaction.Connection, data interface{}) []byte {
 	return data.([]byte)
 }
 
-func New(u *ws.Upgrader) *Protocol {
-	return &Protocol{upgrade: u}
+// checkSize 在等待 payload 之前检查帧和消息长度，避免缓冲超大的帧
+func (p *Protocol) checkSize(c *goreaction.Connection, h *ws.Header) error {
+	if h.OpCode.IsControl() {
+		return nil
+	}
+	if p.maxFrameSize > 0 && h.Length > p.maxFrameSize {
+		return ErrFrameTooBig
+	}
+	if p.maxMessageSize > 0 {
+		total := h.Length
+		if h.OpCode == ws.OpContinuation {
+			if n, ok := c.Get(messageLenKey); ok {
+				total += n.(int64)
+			}
+		}
+		if total > p.maxMessageSize {
+			return ErrMessageTooBig
+		}
+	}
+	return nil
+}
+
+// countMessage 累计分片消息的长度，最后一帧时清零
+func (p *Protocol) countMessage(c *goreaction.Connection, h *ws.Header) {
+	if p.maxMessageSize <= 0 || h.OpCode.IsControl() {
+		return
+	}
+	if h.Fin {
+		c.Delete(messageLenKey)
+		return
+	}
+	total := h.Length
+	if h.OpCode == ws.OpContinuation {
+		if n, ok := c.Get(messageLenKey); ok {
+			total += n.(int64)
+		}
+	}
+	c.Set(messageLenKey, total)
+}
+
+// closeTooBig 回复 1009 close 帧后关闭连接
+func (p *Protocol) closeTooBig(c *goreaction.Connection, err error) {
+	c.Set(closingKey, true)
+	frame, ferr := ws.FrameToBytes(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusMessageTooBig, "")))
+	if ferr == nil {
+		_ = c.Send(frame)
+	}
+	c.CloseWithError(err)
+}
+
+func New(u *ws.Upgrader, opts ...Option) *Protocol {
+	p := &Protocol{upgrade: u}
+	for _, o := range opts {
+		o(p)
+	}
+	return p
 }
@@ -5,7 +5,6 @@ import (
 	"goreaction"
 	"goreaction/plugins/websocket/ws"
 	"goreaction/plugins/websocket/ws/utils"
-	"log"
 )
 
 type WSHandler interface {
@@ -50,18 +49,21 @@ func (s *HandlerWrap) OnMessage(c *goreaction.Connection, ctx interface{}, paylo
 			case ws.OpClose:
 				out, err = utils.HandleClose(header, payload)
 				if err != nil {
-					log.Fatal(err)
+					c.CloseWithError(err)
+					return nil
 				}
 				_ = c.ShutdownWrite()
 			case ws.OpPing:
 				out, err = utils.HandlePing(payload)
 				if err != nil {
-					log.Fatal(err)
+					c.CloseWithError(err)
+					return nil
 				}
 			case ws.OpPong:
 				out, err = utils.HandlePong(payload)
 				if err != nil {
-					log.Fatal(err)
+					c.CloseWithError(err)
+					return nil
 				}
 			}
 			return out
@@ -79,7 +81,8 @@ func (s *HandlerWrap) OnMessage(c *goreaction.Connection, ctx interface{}, paylo
 			var err error
 			out, err = ws.FrameToBytes(frame)
 			if err != nil {
-				log.Fatal(err)
+				c.CloseWithError(err)
+				return nil
 			}
 
 			return out
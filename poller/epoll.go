@@ -4,10 +4,12 @@ package poller
 
 import (
 	"errors"
+	"fmt"
 	"golang.org/x/sys/unix"
 	"log"
 	"runtime"
 	"sync/atomic"
+	"time"
 )
 
 const readEvent = unix.EPOLLIN | unix.EPOLLPRI
@@ -15,6 +17,8 @@ const writeEvent = unix.EPOLLOUT
 
 type Event uint32
 
+const pollErrorBackoff = 10 * time.Millisecond
+
 const (
 	waitEventsBegin       = 1024
 	EventRead       Event = 0x1
@@ -23,26 +27,27 @@ const (
 	EventNone       Event = 0
 )
 
-type Poller struct {
+type epoll struct {
 	fd       int
 	eventFd  int
 	buf      []byte
 	running  atomic.Bool
 	waitDone chan struct{}
+	onError  func(err error)
 }
 
-func New() (*Poller, error) {
+// New 创建 epoll poller
+func New() (Poller, error) {
 	fd, err := unix.EpollCreate1(0)
 	if err != nil {
 		return nil, err
 	}
-	r0, _, errno := unix.Syscall(unix.SYS_EVENTFD2, 0, 0, 0)
-	if errno != 0 {
+	eventFd, err := newEventFd()
+	if err != nil {
 		_ = unix.Close(fd)
-		return nil, errno
+		return nil, err
 	}
 
-	eventFd := int(r0)
 	err = unix.EpollCtl(fd, unix.EPOLL_CTL_ADD, eventFd, &unix.EpollEvent{
 		Events: unix.EPOLLIN,
 		Fd:     int32(eventFd),
@@ -52,29 +57,64 @@ func New() (*Poller, error) {
 		_ = unix.Close(eventFd)
 		return nil, err
 	}
-	return &Poller{
+	return &epoll{
 		fd:       fd,
 		eventFd:  eventFd,
 		buf:      make([]byte, 8),
 		waitDone: make(chan struct{}),
+		onError:  defaultErrorHandler,
 	}, nil
 }
 
+func defaultErrorHandler(err error) {
+	log.Println("[poller]", err)
+}
+
+// SetErrorHandler 设置 Poll 过程中非致命错误的回调，需在 Poll 之前调用
+func (ep *epoll) SetErrorHandler(h func(err error)) {
+	if h == nil {
+		h = defaultErrorHandler
+	}
+	ep.onError = h
+}
+
 var wakeBytes = []byte{1, 0, 0, 0, 0, 0, 0, 0}
 
-func (ep *Poller) Wake() error {
+func newEventFd() (int, error) {
+	r0, _, errno := unix.Syscall(unix.SYS_EVENTFD2, 0, 0, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(r0), nil
+}
+
+// toEvent 将 epoll/poll 事件掩码转换为 Event
+func toEvent(events uint32) (rEvents Event) {
+	if ((events & unix.POLLHUP) != 0) && ((events & unix.POLLIN) == 0) {
+		rEvents |= EventErr
+	}
+	if (events&unix.EPOLLERR != 0) || (events&unix.EPOLLOUT != 0) {
+		rEvents |= EventWrite
+	}
+	if events&(unix.EPOLLIN|unix.EPOLLPRI|unix.EPOLLRDHUP) != 0 {
+		rEvents |= EventRead
+	}
+	return
+}
+
+func (ep *epoll) Wake() error {
 	_, err := unix.Write(ep.eventFd, wakeBytes)
 	return err
 }
 
-func (ep *Poller) wakeHandlerRead() {
+func (ep *epoll) wakeHandlerRead() {
 	n, err := unix.Read(ep.eventFd, ep.buf)
 	if err != nil || n != 8 {
-		log.Fatal("wakeHandlerRead", err, n)
+		ep.onError(fmt.Errorf("wakeHandlerRead: n=%d: %w", n, err))
 	}
 }
 
-func (ep *Poller) Close() error {
+func (ep *epoll) Close() error {
 	if !(ep.running.Load()) {
 		return errors.New("poller instance is not running")
 	}
@@ -88,45 +128,55 @@ func (ep *Poller) Close() error {
 	return nil
 }
 
-func (ep *Poller) add(fd int, events uint32) error {
+func (ep *epoll) add(fd int, events uint32) error {
 	return unix.EpollCtl(ep.fd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{
 		Events: events,
 		Fd:     int32(fd),
 	})
 }
 
-func (ep *Poller) AddRead(fd int) error {
+func (ep *epoll) AddRead(fd int) error {
 	return ep.add(fd, readEvent)
 }
 
-func (ep *Poller) AddWrite(fd int) error {
+// AddEdgeTriggered 以 EPOLLET 注册读写事件，EPOLLRDHUP 使对端关闭写端时也能触发读事件
+func (ep *epoll) AddEdgeTriggered(fd int) error {
+	return ep.add(fd, readEvent|writeEvent|unix.EPOLLRDHUP|unix.EPOLLET)
+}
+
+func (ep *epoll) AddWrite(fd int) error {
 	return ep.add(fd, writeEvent)
 }
 
-func (ep *Poller) Remove(fd int) error {
+func (ep *epoll) Remove(fd int) error {
 	return unix.EpollCtl(ep.fd, unix.EPOLL_CTL_DEL, fd, nil)
 }
 
-func (ep *Poller) modify(fd int, events uint32) error {
+func (ep *epoll) modify(fd int, events uint32) error {
 	return unix.EpollCtl(ep.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{
 		Events: events,
 		Fd:     int32(fd),
 	})
 }
 
-func (ep *Poller) EnableReadWrite(fd int) error {
+func (ep *epoll) EnableReadWrite(fd int) error {
 	return ep.modify(fd, readEvent|writeEvent)
 }
 
-func (ep *Poller) EnableRead(fd int) error {
+func (ep *epoll) EnableRead(fd int) error {
 	return ep.modify(fd, readEvent)
 }
 
-func (ep *Poller) EnableWrite(fd int) error {
+// DisableRead 暂停 fd 的读写事件，fd 仍保留在 epoll 中
+func (ep *epoll) DisableRead(fd int) error {
+	return ep.modify(fd, 0)
+}
+
+func (ep *epoll) EnableWrite(fd int) error {
 	return ep.modify(fd, writeEvent)
 }
 
-func (ep *Poller) Poll(handle func(fd int, event Event)) {
+func (ep *epoll) Poll(handle func(fd int, event Event)) {
 	defer func() {
 		close(ep.waitDone)
 	}()
@@ -143,7 +193,10 @@ func (ep *Poller) Poll(handle func(fd int, event Event)) {
 			if err == unix.EINTR {
 				continue
 			}
-			log.Fatal("EpollWait", err)
+			ep.onError(fmt.Errorf("EpollWait: %w", err))
+			msec = -1
+			time.Sleep(pollErrorBackoff)
+			continue
 		}
 		// it means that when event trigger available, use msec=0 call, otherwise, use msec=-1 to minus polling waste
 		// active switch msec
@@ -157,18 +210,7 @@ func (ep *Poller) Poll(handle func(fd int, event Event)) {
 		for i := 0; i < n; i++ {
 			fd := int(events[i].Fd)
 			if fd != ep.eventFd {
-				var rEvents Event
-				if ((events[i].Events & unix.POLLHUP) != 0) && ((events[i].Events & unix.POLLIN) == 0) {
-					rEvents |= EventErr
-				}
-				if (events[i].Events&unix.EPOLLERR != 0) || (events[i].Events&unix.EPOLLOUT != 0) {
-					rEvents |= EventWrite
-				}
-				if events[i].Events&(unix.EPOLLIN|unix.EPOLLPRI|unix.EPOLLRDHUP) != 0 {
-					rEvents |= EventRead
-				}
-
-				handle(fd, rEvents)
+				handle(fd, toEvent(events[i].Events))
 			} else {
 				ep.wakeHandlerRead()
 				wake = true
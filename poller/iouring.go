@@ -0,0 +1,765 @@
+//go:build linux
+
+package poller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	uringEntries = 1024
+
+	uringOpPollAdd        = 6
+	uringOpPollRemove     = 7
+	uringOpSendmsg        = 9
+	uringOpAccept         = 13
+	uringOpAsyncCancel    = 14
+	uringOpRecv           = 27
+	uringOpProvideBuffers = 31
+
+	uringEnterGetEvents  = 1 << 0
+	uringFeatSingleMmap  = 1 << 0
+	uringFeatFastPoll    = 1 << 5
+	uringSQEBufferSelect = 1 << 5
+	uringCQEFBuffer      = 1 << 0
+	uringCQEBufferShift  = 16
+
+	// uringOpBit user_data 的最高位，区分 accept、recv、sendmsg 请求与 poll 请求
+	uringOpBit = 1 << 63
+
+	// recv 使用内核选择的缓冲区，每个 loop 共 uringRecvBufs 个
+	uringBufGroup    = 1
+	uringRecvBufs    = 256
+	uringRecvBufSize = 16 << 10
+
+	uringOffSQRing = 0
+	uringOffCQRing = 0x8000000
+	uringOffSQEs   = 0x10000000
+
+	uringReadEvents  = unix.POLLIN | unix.POLLPRI
+	uringWriteEvents = unix.POLLOUT
+)
+
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSQOffsets
+	cqOff                                                                  uringCQOffsets
+}
+
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uringFd io_uring 中注册的 fd，每次 poll 完成后按 mask 重新提交
+type uringFd struct {
+	fd    int
+	mask  uint32
+	gen   uint32
+	armed bool
+	// 未完成的 accept、recv、sendmsg 请求，Remove 时取消
+	ops []*uringOp
+	// closing CloseFd 后等待请求完成，全部完成后关闭 fd
+	closing bool
+}
+
+func (f *uringFd) removeOp(op *uringOp) {
+	for i, o := range f.ops {
+		if o == op {
+			last := len(f.ops) - 1
+			f.ops[i], f.ops[last] = f.ops[last], nil
+			f.ops = f.ops[:last]
+			return
+		}
+	}
+}
+
+// uringOp accept、recv、sendmsg 请求，内核访问的地址、iovec 等由 op 保持引用，完成前不会被回收
+type uringOp struct {
+	id       uint64
+	opcode   uint8
+	f        *uringFd
+	complete func(res int32, flags uint32)
+	// mask 返回 EAGAIN 时以 poll 请求等待的事件，polling 为 true 时正在等待
+	mask      uint32
+	polling   bool
+	cancelled bool
+
+	sa    unix.RawSockaddrAny
+	salen uint32
+	bufs  [][]byte
+	iov   []unix.Iovec
+	msg   unix.Msghdr
+}
+
+// ioUring 基于 IORING_OP_POLL_ADD 的 poller。poll 请求是 one-shot 的，事件处理后重新提交，
+// 保持与 epoll 相同的水平触发语义。一次循环中产生的注册、修改、删除请求写入 SQ，
+// 与等待完成合并为一次 io_uring_enter 提交，省去 epoll_ctl 系统调用。
+// 内核支持 FAST_POLL 时还以 uringCompletion 提交 accept、recv、sendmsg，见 Completion；不支持边沿触发
+type ioUring struct {
+	fd      int
+	eventFd int
+	buf     []byte
+
+	sqRing, cqRing, sqeMem []byte
+	sqHead, sqTail, sqMask *uint32
+	sqArray                []uint32
+	sqes                   []uringSQE
+	cqHead, cqTail, cqMask *uint32
+	cqes                   []uringCQE
+	pending                uint32
+
+	fds      map[int]*uringFd
+	wake     *uringFd
+	gen      uint32
+	running  atomic.Bool
+	waitDone chan struct{}
+	onError  func(err error)
+
+	ops  map[uint64]*uringOp
+	opID uint64
+	bufs []byte
+	// starved 缓冲区用尽时等待归还的 recv，cancelled 其中被取消、在本轮回调的请求
+	starved   []*uringOp
+	cancelled []*uringOp
+	// closing CloseFd 后仍有未完成请求的 fd，poller 关闭时一并关闭
+	closing map[int]*uringFd
+}
+
+// uringCompletion 以 accept、recv、sendmsg 请求收发的 io_uring poller，实现 Completion
+type uringCompletion struct {
+	*ioUring
+}
+
+// NewIOUring 创建 io_uring poller，内核不支持或被禁用时返回错误。
+// 内核支持 FAST_POLL 和 recv 缓冲区时返回的 poller 实现 Completion
+func NewIOUring() (Poller, error) {
+	var params uringParams
+	r0, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uringEntries, uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	u := &ioUring{
+		fd:       int(r0),
+		buf:      make([]byte, 8),
+		fds:      make(map[int]*uringFd),
+		ops:      make(map[uint64]*uringOp),
+		closing:  make(map[int]*uringFd),
+		waitDone: make(chan struct{}),
+		onError:  defaultErrorHandler,
+	}
+	if err := u.mmap(&params); err != nil {
+		u.release()
+		return nil, err
+	}
+
+	eventFd, err := newEventFd()
+	if err != nil {
+		u.release()
+		return nil, err
+	}
+	u.eventFd = eventFd
+	completion := params.features&uringFeatFastPoll != 0 && u.provideBuffers() == nil
+	u.wake = &uringFd{fd: eventFd, mask: unix.POLLIN}
+	u.arm(u.wake)
+	if completion {
+		return &uringCompletion{u}, nil
+	}
+	return u, nil
+}
+
+// provideBuffers 提供 recv 的缓冲区，同步等待完成以确认内核支持
+func (u *ioUring) provideBuffers() error {
+	u.bufs = make([]byte, uringRecvBufs*uringRecvBufSize)
+	sqe := u.getSQE()
+	sqe.opcode = uringOpProvideBuffers
+	sqe.fd = uringRecvBufs
+	sqe.addr = uint64(uintptr(unsafe.Pointer(&u.bufs[0])))
+	sqe.len = uringRecvBufSize
+	sqe.bufIndex = uringBufGroup
+	if err := u.enter(1); err != nil {
+		u.bufs = nil
+		return err
+	}
+	head := *u.cqHead
+	cqe := u.cqes[head&*u.cqMask]
+	atomic.StoreUint32(u.cqHead, head+1)
+	if cqe.res < 0 {
+		u.bufs = nil
+		return unix.Errno(-cqe.res)
+	}
+	return nil
+}
+
+func (u *ioUring) mmap(p *uringParams) (err error) {
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	if p.features&uringFeatSingleMmap != 0 && cqSize > sqSize {
+		sqSize = cqSize
+	}
+
+	if u.sqRing, err = unix.Mmap(u.fd, uringOffSQRing, sqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		return fmt.Errorf("mmap sq ring: %w", err)
+	}
+	if p.features&uringFeatSingleMmap != 0 {
+		u.cqRing = u.sqRing
+	} else if u.cqRing, err = unix.Mmap(u.fd, uringOffCQRing, cqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		return fmt.Errorf("mmap cq ring: %w", err)
+	}
+	sqeSize := int(p.sqEntries) * int(unsafe.Sizeof(uringSQE{}))
+	if u.sqeMem, err = unix.Mmap(u.fd, uringOffSQEs, sqeSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		return fmt.Errorf("mmap sqes: %w", err)
+	}
+
+	sq, cq := unsafe.Pointer(&u.sqRing[0]), unsafe.Pointer(&u.cqRing[0])
+	u.sqHead = (*uint32)(unsafe.Add(sq, p.sqOff.head))
+	u.sqTail = (*uint32)(unsafe.Add(sq, p.sqOff.tail))
+	u.sqMask = (*uint32)(unsafe.Add(sq, p.sqOff.ringMask))
+	u.sqArray = unsafe.Slice((*uint32)(unsafe.Add(sq, p.sqOff.array)), p.sqEntries)
+	u.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&u.sqeMem[0])), p.sqEntries)
+	u.cqHead = (*uint32)(unsafe.Add(cq, p.cqOff.head))
+	u.cqTail = (*uint32)(unsafe.Add(cq, p.cqOff.tail))
+	u.cqMask = (*uint32)(unsafe.Add(cq, p.cqOff.ringMask))
+	u.cqes = unsafe.Slice((*uringCQE)(unsafe.Add(cq, p.cqOff.cqes)), p.cqEntries)
+	return nil
+}
+
+func (u *ioUring) release() {
+	if u.sqeMem != nil {
+		_ = unix.Munmap(u.sqeMem)
+	}
+	if u.cqRing != nil && &u.cqRing[0] != &u.sqRing[0] {
+		_ = unix.Munmap(u.cqRing)
+	}
+	if u.sqRing != nil {
+		_ = unix.Munmap(u.sqRing)
+	}
+	if u.eventFd > 0 {
+		_ = unix.Close(u.eventFd)
+	}
+	_ = unix.Close(u.fd)
+	for fd := range u.closing {
+		_ = unix.Close(fd)
+	}
+}
+
+func (u *ioUring) SetErrorHandler(h func(err error)) {
+	if h == nil {
+		h = defaultErrorHandler
+	}
+	u.onError = h
+}
+
+// getSQE SQ 已满时先提交已有请求
+func (u *ioUring) getSQE() *uringSQE {
+	tail := *u.sqTail
+	for tail-atomic.LoadUint32(u.sqHead) >= uint32(len(u.sqes)) {
+		if err := u.enter(0); err != nil {
+			u.onError(fmt.Errorf("io_uring_enter: %w", err))
+		}
+	}
+	idx := tail & *u.sqMask
+	sqe := &u.sqes[idx]
+	*sqe = uringSQE{}
+	u.sqArray[idx] = idx
+	atomic.StoreUint32(u.sqTail, tail+1)
+	u.pending++
+	return sqe
+}
+
+// enter 提交所有待提交的请求，minComplete > 0 时等待完成
+func (u *ioUring) enter(minComplete uint32) error {
+	var flags uintptr
+	if minComplete > 0 {
+		flags = uringEnterGetEvents
+	}
+	r0, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(u.fd), uintptr(u.pending), uintptr(minComplete), flags, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	u.pending -= uint32(r0)
+	return nil
+}
+
+func (u *ioUring) nextGen() uint32 {
+	u.gen++
+	if u.gen == 0 {
+		u.gen = 1
+	}
+	return u.gen
+}
+
+// arm 取消已提交的 poll 并按当前 mask 重新提交，gen 用于识别过期的完成事件
+func (u *ioUring) arm(f *uringFd) {
+	if f.armed {
+		sqe := u.getSQE()
+		sqe.opcode = uringOpPollRemove
+		sqe.fd = -1
+		sqe.addr = uint64(f.fd)<<32 | uint64(f.gen)
+		f.armed = false
+	}
+	if f.mask == 0 {
+		// 已提交的 poll 的完成事件不再处理
+		f.gen = 0
+		return
+	}
+	f.gen = u.nextGen()
+	sqe := u.getSQE()
+	sqe.opcode = uringOpPollAdd
+	sqe.fd = int32(f.fd)
+	sqe.opFlags = f.mask
+	sqe.userData = uint64(f.fd)<<32 | uint64(f.gen)
+	f.armed = true
+}
+
+func (u *ioUring) add(fd int, mask uint32) error {
+	if _, ok := u.fds[fd]; ok {
+		return unix.EEXIST
+	}
+	f := &uringFd{fd: fd, mask: mask}
+	u.fds[fd] = f
+	u.arm(f)
+	return nil
+}
+
+func (u *ioUring) modify(fd int, mask uint32) error {
+	f, ok := u.fds[fd]
+	if !ok {
+		return unix.ENOENT
+	}
+	if f.mask == mask && (f.armed || mask == 0) {
+		return nil
+	}
+	f.mask = mask
+	u.arm(f)
+	return nil
+}
+
+func (u *ioUring) AddRead(fd int) error {
+	return u.add(fd, uringReadEvents)
+}
+
+func (u *ioUring) AddWrite(fd int) error {
+	return u.add(fd, uringWriteEvents)
+}
+
+func (u *ioUring) EnableRead(fd int) error {
+	return u.modify(fd, uringReadEvents)
+}
+
+func (u *ioUring) EnableWrite(fd int) error {
+	return u.modify(fd, uringWriteEvents)
+}
+
+func (u *ioUring) EnableReadWrite(fd int) error {
+	return u.modify(fd, uringReadEvents|uringWriteEvents)
+}
+
+func (u *ioUring) DisableRead(fd int) error {
+	return u.modify(fd, 0)
+}
+
+// Remove 取消 fd 的 poll 和未完成的请求，取消请求在下一次 io_uring_enter 时提交
+func (u *ioUring) Remove(fd int) error {
+	f, ok := u.fds[fd]
+	if !ok {
+		return unix.ENOENT
+	}
+	delete(u.fds, fd)
+	f.mask = 0
+	u.arm(f)
+	for _, op := range f.ops {
+		u.cancel(op)
+	}
+	f.ops = nil
+	return nil
+}
+
+func (u *ioUring) Wake() error {
+	_, err := unix.Write(u.eventFd, wakeBytes)
+	return err
+}
+
+func (u *ioUring) Close() error {
+	if !(u.running.Load()) {
+		return errors.New("poller instance is not running")
+	}
+	u.running.Store(false)
+	if err := u.Wake(); err != nil {
+		return err
+	}
+	<-u.waitDone
+	u.release()
+	return nil
+}
+
+func (u *ioUring) Poll(handle func(fd int, event Event)) {
+	defer func() {
+		close(u.waitDone)
+	}()
+
+	var wake bool
+	u.running.Store(true)
+	for {
+		if err := u.enter(1); err != nil {
+			switch err {
+			case unix.EINTR:
+				continue
+			case unix.EBUSY, unix.EAGAIN:
+				// CQ 溢出或内核暂时无法分配请求，先处理已完成的事件
+			default:
+				u.onError(fmt.Errorf("io_uring_enter: %w", err))
+				time.Sleep(pollErrorBackoff)
+				continue
+			}
+		}
+
+		head, tail := *u.cqHead, atomic.LoadUint32(u.cqTail)
+		for ; head != tail; head++ {
+			cqe := u.cqes[head&*u.cqMask]
+			// 先归还 CQ 空间，handle 中提交请求时可能需要等待
+			atomic.StoreUint32(u.cqHead, head+1)
+
+			if cqe.userData&uringOpBit != 0 {
+				u.complete(cqe)
+				continue
+			}
+			fd, gen := int(cqe.userData>>32), uint32(cqe.userData)
+			if gen == 0 {
+				// POLL_REMOVE 的完成事件
+				continue
+			}
+			if fd == u.eventFd {
+				if u.wake.gen == gen {
+					u.wake.armed = false
+					u.wakeHandlerRead()
+					u.arm(u.wake)
+					wake = true
+				}
+				continue
+			}
+
+			f, ok := u.fds[fd]
+			if !ok || f.gen != gen {
+				continue
+			}
+			f.armed = false
+			if cqe.res < 0 {
+				if cqe.res == -int32(unix.ECANCELED) {
+					continue
+				}
+				// 不再重新提交，避免错误的 fd 反复触发
+				u.onError(fmt.Errorf("io_uring poll fd %d: %w", fd, unix.Errno(-cqe.res)))
+				handle(fd, EventErr)
+				continue
+			}
+			handle(fd, toEvent(uint32(cqe.res)))
+			if cur, ok := u.fds[fd]; ok && cur == f && !f.armed {
+				u.arm(f)
+			}
+		}
+
+		for len(u.cancelled) > 0 {
+			op := u.cancelled[0]
+			u.cancelled = u.cancelled[1:]
+			u.finish(op, -int32(unix.ECANCELED), 0)
+		}
+
+		if wake {
+			handle(-1, 0)
+			wake = false
+			if !u.running.Load() {
+				return
+			}
+		}
+	}
+}
+
+func (u *ioUring) wakeHandlerRead() {
+	n, err := unix.Read(u.eventFd, u.buf)
+	if err != nil || n != 8 {
+		u.onError(fmt.Errorf("wakeHandlerRead: n=%d: %w", n, err))
+	}
+}
+
+func (u *uringCompletion) AddCompletion(fd int) error {
+	return u.add(fd, 0)
+}
+
+// CloseFd 取消 poll、accept、recv 和等待可写的 sendmsg，已提交的 sendmsg 照常完成，
+// 全部请求完成后再关闭 fd，避免 fd 号被复用后未提交的请求作用到新的 socket 上
+func (u *uringCompletion) CloseFd(fd int) error {
+	f, ok := u.fds[fd]
+	if !ok {
+		return unix.ENOENT
+	}
+	delete(u.fds, fd)
+	f.mask = 0
+	u.arm(f)
+	for _, op := range f.ops {
+		if op.opcode != uringOpSendmsg || op.polling {
+			u.cancel(op)
+		}
+	}
+	f.closing = true
+	if len(f.ops) == 0 {
+		return unix.Close(fd)
+	}
+	u.closing[fd] = f
+	return nil
+}
+
+func (u *uringCompletion) Accept(fd int, done func(nfd int, sa unix.Sockaddr, err error)) error {
+	op := &uringOp{opcode: uringOpAccept, mask: uringReadEvents}
+	op.complete = func(res int32, flags uint32) {
+		if res < 0 {
+			done(-1, nil, unix.Errno(-res))
+			return
+		}
+		done(int(res), sockaddrOf(&op.sa, op.salen), nil)
+	}
+	return u.submit(fd, op)
+}
+
+func (u *uringCompletion) Recv(fd int, done func(data []byte, err error)) error {
+	return u.submit(fd, &uringOp{opcode: uringOpRecv, mask: uringReadEvents, complete: func(res int32, flags uint32) {
+		if flags&uringCQEFBuffer == 0 {
+			// 未取得缓冲区：出错、被取消或对端关闭
+			if res < 0 {
+				done(nil, unix.Errno(-res))
+			} else {
+				done(nil, nil)
+			}
+			return
+		}
+		bid := int(flags >> uringCQEBufferShift)
+		if res < 0 {
+			done(nil, unix.Errno(-res))
+		} else {
+			off := bid * uringRecvBufSize
+			done(u.bufs[off:off+int(res):off+uringRecvBufSize], nil)
+		}
+		u.provide(bid)
+	}})
+}
+
+func (u *uringCompletion) Send(fd int, bufs [][]byte, done func(n int, err error)) error {
+	op := &uringOp{opcode: uringOpSendmsg, mask: uringWriteEvents, bufs: bufs}
+	op.iov = make([]unix.Iovec, 0, len(bufs))
+	for _, b := range bufs {
+		if len(b) == 0 {
+			continue
+		}
+		v := unix.Iovec{Base: &b[0]}
+		v.SetLen(len(b))
+		op.iov = append(op.iov, v)
+	}
+	if len(op.iov) > 0 {
+		op.msg.Iov = &op.iov[0]
+		op.msg.SetIovlen(len(op.iov))
+	}
+	op.complete = func(res int32, flags uint32) {
+		if res < 0 {
+			done(0, unix.Errno(-res))
+			return
+		}
+		done(int(res), nil)
+	}
+	return u.submit(fd, op)
+}
+
+// submit 提交 fd 上的请求，fd 需已注册
+func (u *uringCompletion) submit(fd int, op *uringOp) error {
+	f, ok := u.fds[fd]
+	if !ok {
+		return unix.ENOENT
+	}
+	u.opID++
+	op.id = u.opID
+	op.f = f
+	f.ops = append(f.ops, op)
+	u.ops[op.id] = op
+	u.issue(op)
+	return nil
+}
+
+// issue 把 op 写入 SQ，EAGAIN 或缓冲区用尽后再次提交时也使用
+func (u *ioUring) issue(op *uringOp) {
+	sqe := u.getSQE()
+	sqe.opcode = op.opcode
+	sqe.fd = int32(op.f.fd)
+	sqe.userData = uringOpBit | op.id
+	switch op.opcode {
+	case uringOpAccept:
+		op.salen = unix.SizeofSockaddrAny
+		sqe.addr = uint64(uintptr(unsafe.Pointer(&op.sa)))
+		sqe.off = uint64(uintptr(unsafe.Pointer(&op.salen)))
+		sqe.opFlags = unix.SOCK_NONBLOCK | unix.SOCK_CLOEXEC
+	case uringOpRecv:
+		sqe.flags = uringSQEBufferSelect
+		sqe.len = uringRecvBufSize
+		sqe.bufIndex = uringBufGroup
+	case uringOpSendmsg:
+		sqe.addr = uint64(uintptr(unsafe.Pointer(&op.msg)))
+		sqe.len = 1
+		sqe.opFlags = unix.MSG_NOSIGNAL
+	}
+}
+
+// complete 处理请求的完成事件，返回 EAGAIN 时等待就绪后重新提交，缓冲区用尽时等待归还
+func (u *ioUring) complete(cqe uringCQE) {
+	op, ok := u.ops[cqe.userData&^uringOpBit]
+	if !ok {
+		return
+	}
+	res := cqe.res
+	if op.polling {
+		op.polling = false
+		if res >= 0 && !op.cancelled {
+			u.issue(op)
+			return
+		}
+		if res >= 0 {
+			res = -int32(unix.ECANCELED)
+		}
+	}
+	if !op.cancelled {
+		switch {
+		case res == -int32(unix.EAGAIN) && !op.f.closing:
+			op.polling = true
+			sqe := u.getSQE()
+			sqe.opcode = uringOpPollAdd
+			sqe.fd = int32(op.f.fd)
+			sqe.opFlags = op.mask
+			sqe.userData = uringOpBit | op.id
+			return
+		case res == -int32(unix.ENOBUFS) && op.opcode == uringOpRecv:
+			u.starved = append(u.starved, op)
+			return
+		}
+	}
+	u.finish(op, res, cqe.flags)
+}
+
+// finish 回调 op，fd 已被 Remove 时以 ECANCELED 回调，已接受的连接被关闭
+func (u *ioUring) finish(op *uringOp, res int32, flags uint32) {
+	delete(u.ops, op.id)
+	op.f.removeOp(op)
+	if op.cancelled && res >= 0 {
+		if op.opcode == uringOpAccept {
+			_ = unix.Close(int(res))
+		}
+		res = -int32(unix.ECANCELED)
+	}
+	op.complete(res, flags)
+	if f := op.f; f.closing && len(f.ops) == 0 {
+		delete(u.closing, f.fd)
+		if err := unix.Close(f.fd); err != nil {
+			u.onError(fmt.Errorf("io_uring close fd %d: %w", f.fd, err))
+		}
+	}
+}
+
+// cancel 取消 op，等待缓冲区的 recv 在本轮 Poll 中回调
+func (u *ioUring) cancel(op *uringOp) {
+	op.cancelled = true
+	for i, s := range u.starved {
+		if s == op {
+			u.starved = append(u.starved[:i], u.starved[i+1:]...)
+			u.cancelled = append(u.cancelled, op)
+			return
+		}
+	}
+	sqe := u.getSQE()
+	sqe.opcode = uringOpAsyncCancel
+	sqe.fd = -1
+	sqe.addr = uringOpBit | op.id
+}
+
+// provide 归还 recv 缓冲区，并重新提交一个等待缓冲区的 recv
+func (u *ioUring) provide(bid int) {
+	sqe := u.getSQE()
+	sqe.opcode = uringOpProvideBuffers
+	sqe.fd = 1
+	sqe.addr = uint64(uintptr(unsafe.Pointer(&u.bufs[bid*uringRecvBufSize])))
+	sqe.len = uringRecvBufSize
+	sqe.off = uint64(bid)
+	sqe.bufIndex = uringBufGroup
+
+	if len(u.starved) > 0 {
+		op := u.starved[0]
+		u.starved = u.starved[1:]
+		u.issue(op)
+	}
+}
+
+// sockaddrOf 把 accept 写入的地址转换为 unix.Sockaddr
+func sockaddrOf(rsa *unix.RawSockaddrAny, salen uint32) unix.Sockaddr {
+	switch rsa.Addr.Family {
+	case unix.AF_INET:
+		p := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		port := (*[2]byte)(unsafe.Pointer(&p.Port))
+		return &unix.SockaddrInet4{Port: int(port[0])<<8 | int(port[1]), Addr: p.Addr}
+	case unix.AF_INET6:
+		p := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		port := (*[2]byte)(unsafe.Pointer(&p.Port))
+		return &unix.SockaddrInet6{Port: int(port[0])<<8 | int(port[1]), ZoneId: p.Scope_id, Addr: p.Addr}
+	case unix.AF_UNIX:
+		p := (*unix.RawSockaddrUnix)(unsafe.Pointer(rsa))
+		n := int(salen) - 2
+		if n > len(p.Path) {
+			n = len(p.Path)
+		}
+		if n <= 0 {
+			return &unix.SockaddrUnix{}
+		}
+		name := make([]byte, n)
+		for i := range name {
+			name[i] = byte(p.Path[i])
+		}
+		if name[0] == 0 {
+			// 抽象地址
+			name[0] = '@'
+		}
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		return &unix.SockaddrUnix{Name: string(name)}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package poller
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+)
+
+// Poller loop 使用的 I/O 多路复用后端，除 Wake 外只能在 Poll 所在的 goroutine 中调用
+type Poller interface {
+	AddRead(fd int) error
+	AddWrite(fd int) error
+	EnableRead(fd int) error
+	EnableWrite(fd int) error
+	EnableReadWrite(fd int) error
+	// DisableRead 暂停 fd 的读写事件，fd 仍保留在 poller 中
+	DisableRead(fd int) error
+	Remove(fd int) error
+	Wake() error
+	// Poll 阻塞等待事件，fd 为 -1 表示被 Wake 唤醒，Close 后返回
+	Poll(handle func(fd int, event Event))
+	Close() error
+	// SetErrorHandler 设置 Poll 过程中非致命错误的回调，需在 Poll 之前调用
+	SetErrorHandler(h func(err error))
+}
+
+// EdgeTriggered 支持边沿触发的 poller 实现
+type EdgeTriggered interface {
+	// AddEdgeTriggered 以边沿触发注册 fd 的读写事件，之后无需再修改关注的事件
+	AddEdgeTriggered(fd int) error
+}
+
+// Completion 以完成事件执行 accept、recv、sendmsg 的 poller。请求写入提交队列，
+// 与同一轮产生的其他请求在 Poll 等待时合并为一次系统调用提交。
+// 除 AddCompletion 外返回 nil 时 done 在 Poll 所在的 goroutine 中恰好回调一次，
+// fd 被 Remove 时未完成的请求被取消，以 ECANCELED 或已完成的结果回调
+type Completion interface {
+	// AddCompletion 注册 fd，不关注就绪事件，之后由 Accept、Recv、Send 提交请求
+	AddCompletion(fd int) error
+	// Accept 接受一个连接，新连接为非阻塞
+	Accept(fd int, done func(nfd int, sa unix.Sockaddr, err error)) error
+	// Recv 读取到 poller 的缓冲区，data 只在 done 中有效，长度为 0 且 err 为 nil 表示对端关闭
+	Recv(fd int, done func(data []byte, err error)) error
+	// Send 以一次 sendmsg 写出 bufs，done 回调前 bufs 不能修改
+	Send(fd int, bufs [][]byte, done func(n int, err error)) error
+	// CloseFd 移除并关闭 fd，已提交的 Send 写完后才关闭，其余请求被取消
+	CloseFd(fd int) error
+}
+
+// Backend poller 的实现
+type Backend string
+
+const (
+	BackendEpoll   Backend = "epoll"
+	BackendIOUring Backend = "io_uring"
+)
+
+// NewBackend 创建指定后端的 poller，为空时使用 epoll。
+// 内核不支持 io_uring 时退回 epoll。内核支持 IORING_FEAT_FAST_POLL 时 io_uring 后端实现 Completion
+func NewBackend(b Backend) (Poller, error) {
+	switch b {
+	case "", BackendEpoll:
+		return New()
+	case BackendIOUring:
+		p, err := NewIOUring()
+		if err != nil {
+			return New()
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown poller backend: %s", b)
+}
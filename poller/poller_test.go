@@ -5,6 +5,7 @@ package poller
 
 import (
 	"errors"
+	"golang.org/x/sys/unix"
 	"testing"
 	"time"
 )
@@ -45,3 +46,241 @@ func TestPoller_Close(t *testing.T) {
 		t.Fatal("poller should be closed")
 	}
 }
+
+func TestIOUring_Poll(t *testing.T) {
+	p, err := NewIOUring()
+	if err != nil {
+		t.Skip("io_uring not supported:", err)
+	}
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	events := make(chan Event, 16)
+	if err = p.AddRead(fds[0]); err != nil {
+		t.Fatal(err)
+	}
+	go p.Poll(func(fd int, event Event) {
+		if fd == fds[0] {
+			// 水平触发：读完数据后才不再触发
+			_, _ = unix.Read(fd, make([]byte, 16))
+			events <- event
+		}
+	})
+
+	if _, err = unix.Write(fds[1], []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-events:
+		if ev&EventRead == 0 {
+			t.Fatalf("event should be read, but %v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read event timeout")
+	}
+
+	// 没有新数据时不应再触发
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event %v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewBackend(t *testing.T) {
+	for _, b := range []Backend{"", BackendEpoll, BackendIOUring} {
+		p, err := NewBackend(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go p.Poll(func(fd int, event Event) {})
+		time.Sleep(50 * time.Millisecond)
+		if err = p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := NewBackend("kqueue"); err == nil {
+		t.Fatal("unknown backend should fail")
+	}
+}
+
+func TestIOUring_Completion(t *testing.T) {
+	p, err := NewIOUring()
+	if err != nil {
+		t.Skip("io_uring not supported:", err)
+	}
+	u, ok := p.(Completion)
+	if !ok {
+		_ = p.Close()
+		t.Skip("io_uring completion not supported")
+	}
+
+	ln, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(ln)
+	if err = unix.Bind(ln, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = unix.Listen(ln, 16); err != nil {
+		t.Fatal(err)
+	}
+	lsa, _ := unix.Getsockname(ln)
+
+	// 第一个请求在 Poll 之前提交，之后的请求都在回调中（Poll 所在的 goroutine）提交
+	results := make(chan string, 16)
+	if err = u.AddCompletion(ln); err != nil {
+		t.Fatal(err)
+	}
+	err = u.Accept(ln, func(nfd int, sa unix.Sockaddr, err error) {
+		if err != nil {
+			results <- "accept: " + err.Error()
+			return
+		}
+		_ = u.AddCompletion(nfd)
+		_ = u.Recv(nfd, func(data []byte, err error) {
+			results <- "recv: " + string(data)
+			_ = u.Send(nfd, [][]byte{[]byte("po"), nil, []byte("ng")}, func(n int, err error) {
+				if err != nil || n != 4 {
+					results <- "send failed"
+				}
+				// 未完成的 recv 被 Remove 取消
+				_ = u.Recv(nfd, func(data []byte, err error) {
+					results <- "cancel: " + err.Error()
+					_ = unix.Close(nfd)
+				})
+				_ = p.Remove(nfd)
+			})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Poll(func(fd int, event Event) {})
+	defer p.Close()
+
+	c, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(c)
+	if err = unix.Connect(c, lsa); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = unix.Write(c, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if n, err := unix.Read(c, buf); err != nil || string(buf[:n]) != "pong" {
+		t.Fatalf("reply should be pong, but %q %v", buf[:n], err)
+	}
+
+	for _, want := range []string{"recv: ping", "cancel: " + unix.ECANCELED.Error()} {
+		select {
+		case got := <-results:
+			if got != want {
+				t.Fatalf("result should be %q, but %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q timeout", want)
+		}
+	}
+	// 连接关闭后读到 EOF
+	if n, err := unix.Read(c, buf); n != 0 || err != nil {
+		t.Fatalf("read should return EOF, but %d %v", n, err)
+	}
+}
+
+func TestIOUring_CloseFd(t *testing.T) {
+	p, err := NewIOUring()
+	if err != nil {
+		t.Skip("io_uring not supported:", err)
+	}
+	u, ok := p.(Completion)
+	if !ok {
+		_ = p.Close()
+		t.Skip("io_uring completion not supported")
+	}
+
+	ln, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(ln)
+	if err = unix.Bind(ln, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = unix.Listen(ln, 16); err != nil {
+		t.Fatal(err)
+	}
+	lsa, _ := unix.Getsockname(ln)
+
+	results := make(chan string, 16)
+	if err = u.AddCompletion(ln); err != nil {
+		t.Fatal(err)
+	}
+	err = u.Accept(ln, func(nfd int, sa unix.Sockaddr, err error) {
+		_ = u.AddCompletion(nfd)
+		_ = u.Recv(nfd, func(data []byte, err error) {
+			results <- "recv: " + string(data)
+			// sendmsg 与 CloseFd 在同一轮提交，写完后才关闭 fd，未完成的 recv 被取消
+			_ = u.Send(nfd, [][]byte{[]byte("bye")}, func(n int, err error) {
+				if err != nil || n != 3 {
+					results <- "send failed"
+				}
+			})
+			_ = u.Recv(nfd, func(data []byte, err error) {
+				results <- "cancel: " + err.Error()
+			})
+			if err := u.CloseFd(nfd); err != nil {
+				results <- "close: " + err.Error()
+			}
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Poll(func(fd int, event Event) {})
+	defer p.Close()
+
+	c, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(c)
+	if err = unix.Connect(c, lsa); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = unix.Write(c, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8)
+	if n, err := unix.Read(c, buf); err != nil || string(buf[:n]) != "bye" {
+		t.Fatalf("reply should be bye, but %q %v", buf[:n], err)
+	}
+	if n, err := unix.Read(c, buf); n != 0 || err != nil {
+		t.Fatalf("read should return EOF, but %d %v", n, err)
+	}
+
+	for _, want := range []string{"recv: ping", "cancel: " + unix.ECANCELED.Error()} {
+		select {
+		case got := <-results:
+			if got != want {
+				t.Fatalf("result should be %q, but %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q timeout", want)
+		}
+	}
+}
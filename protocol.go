@@ -7,6 +7,11 @@ type Protocol interface {
 	Packet(c *Connection, data interface{}) []byte
 }
 
+// ReadBufferLimiter Protocol 可选实现，返回值大于 0 时覆盖 Options.MaxReadBufferSize
+type ReadBufferLimiter interface {
+	MaxReadBufferSize() int
+}
+
 type DefaultProtocol struct{}
 
 func (d *DefaultProtocol) UnPacket(c *Connection, buf *ringbuffer.RingBuffer) (interface{}, []byte) {
@@ -1,10 +1,15 @@
 package goreaction
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"goreaction/eventloop"
 	"goreaction/ringbuffer"
+	"io"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestDefaultProtocol_UnPacket(t *testing.T) {
@@ -33,3 +38,85 @@ func newTmpConnection() *Connection {
 		loop: lp,
 	}
 }
+
+// lineProtocol 收到换行才取走数据
+type lineProtocol struct {
+	limit int
+}
+
+func (p *lineProtocol) UnPacket(c *Connection, buf *ringbuffer.RingBuffer) (interface{}, []byte) {
+	s, e := buf.PeekAll()
+	data := append(append([]byte{}, s...), e...)
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		buf.Retrieve(i + 1)
+		return nil, data[:i+1]
+	}
+	return nil, nil
+}
+
+func (p *lineProtocol) Packet(c *Connection, data interface{}) []byte {
+	return data.([]byte)
+}
+
+func (p *lineProtocol) MaxReadBufferSize() int {
+	return p.limit
+}
+
+func TestMaxReadBufferSize(t *testing.T) {
+	for i, limit := range []int{0, 1024} {
+		addr := fmt.Sprintf("127.0.0.1:%d", 12373+i)
+		errs := make(chan error, 1)
+		s, err := NewServer(new(echoExample), Address(addr), NumLoops(1),
+			CustomProtocol(&lineProtocol{limit: limit}), MaxReadBufferSize(4096),
+			OnError(func(err *OpError) {
+				select {
+				case errs <- err.Err:
+				default:
+				}
+			}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go s.Start()
+		time.Sleep(100 * time.Millisecond)
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write([]byte("ping\n")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+
+		// 未达上限时继续缓冲
+		size := 4096
+		if limit > 0 {
+			size = limit
+		}
+		if _, err := conn.Write(make([]byte, size-1)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		select {
+		case err := <-errs:
+			t.Fatalf("unexpected error %v", err)
+		default:
+		}
+
+		if _, err := conn.Write([]byte("xx")); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(buf); err != io.EOF {
+			t.Fatalf("connection should be closed, but %v", err)
+		}
+		assert.ErrorIs(t, <-errs, ErrReadBufferFull)
+
+		_ = conn.Close()
+		s.Stop()
+	}
+}
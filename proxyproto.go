@@ -0,0 +1,265 @@
+package goreaction
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/sys/unix"
+	"net"
+	"strconv"
+)
+
+var (
+	ErrProxyHeader    = errors.New("proxy protocol: invalid header")
+	ErrUntrustedProxy = errors.New("proxy protocol: untrusted source")
+)
+
+const (
+	proxyV1MaxLen  = 107
+	proxyV2HeadLen = 16
+)
+
+var (
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Sig    = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+)
+
+// PROXY protocol v2 常用 TLV 类型
+const (
+	PP2TypeALPN      byte = 0x01
+	PP2TypeAuthority byte = 0x02
+	PP2TypeCRC32C    byte = 0x03
+	PP2TypeNoop      byte = 0x04
+	PP2TypeUniqueID  byte = 0x05
+	PP2TypeSSL       byte = 0x20
+	PP2TypeNetNS     byte = 0x30
+)
+
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeader 解析出的 PROXY protocol 头。Local 为 true 时（v2 LOCAL 命令或 v1 UNKNOWN）
+// 地址为空，连接保留原始地址
+type ProxyHeader struct {
+	Version    int
+	Local      bool
+	SourceAddr string
+	DestAddr   string
+	TLVs       []ProxyTLV
+}
+
+// proxyState 等待 PROXY 头的连接状态
+type proxyState struct {
+	buf     []byte
+	onReady func()
+}
+
+// parseProxyHeader 解析 data 开头的 PROXY 头，数据不足时返回 n == 0
+func parseProxyHeader(data []byte) (h *ProxyHeader, n int, err error) {
+	switch {
+	case len(data) >= len(proxyV2Sig) && bytes.Equal(data[:len(proxyV2Sig)], proxyV2Sig):
+		return parseProxyV2(data)
+	case len(data) < len(proxyV2Sig) && bytes.HasPrefix(proxyV2Sig, data):
+		return nil, 0, nil
+	case len(data) >= len(proxyV1Prefix) && bytes.Equal(data[:len(proxyV1Prefix)], proxyV1Prefix):
+		return parseProxyV1(data)
+	case len(data) < len(proxyV1Prefix) && bytes.HasPrefix(proxyV1Prefix, data):
+		return nil, 0, nil
+	}
+	return nil, 0, ErrProxyHeader
+}
+
+func parseProxyV1(data []byte) (*ProxyHeader, int, error) {
+	end := bytes.Index(data, []byte("\r\n"))
+	if end < 0 {
+		if len(data) >= proxyV1MaxLen {
+			return nil, 0, ErrProxyHeader
+		}
+		return nil, 0, nil
+	}
+	if end+2 > proxyV1MaxLen {
+		return nil, 0, ErrProxyHeader
+	}
+
+	fields := bytes.Split(data[len(proxyV1Prefix):end], []byte(" "))
+	h := &ProxyHeader{Version: 1}
+	switch string(fields[0]) {
+	case "UNKNOWN":
+		h.Local = true
+		return h, end + 2, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, 0, ErrProxyHeader
+	}
+	if len(fields) != 5 {
+		return nil, 0, ErrProxyHeader
+	}
+
+	src, dst := net.ParseIP(string(fields[1])), net.ParseIP(string(fields[2]))
+	if src == nil || dst == nil || (string(fields[0]) == "TCP4") != (src.To4() != nil) {
+		return nil, 0, ErrProxyHeader
+	}
+	sport, err1 := strconv.ParseUint(string(fields[3]), 10, 16)
+	dport, err2 := strconv.ParseUint(string(fields[4]), 10, 16)
+	if err1 != nil || err2 != nil {
+		return nil, 0, ErrProxyHeader
+	}
+
+	h.SourceAddr = net.JoinHostPort(src.String(), strconv.Itoa(int(sport)))
+	h.DestAddr = net.JoinHostPort(dst.String(), strconv.Itoa(int(dport)))
+	return h, end + 2, nil
+}
+
+func parseProxyV2(data []byte) (*ProxyHeader, int, error) {
+	if len(data) < proxyV2HeadLen {
+		return nil, 0, nil
+	}
+	verCmd, fam := data[12], data[13]
+	if verCmd>>4 != 2 {
+		return nil, 0, ErrProxyHeader
+	}
+	length := int(binary.BigEndian.Uint16(data[14:16]))
+	n := proxyV2HeadLen + length
+	if len(data) < n {
+		return nil, 0, nil
+	}
+
+	h := &ProxyHeader{Version: 2}
+	payload := data[proxyV2HeadLen:n]
+	switch verCmd & 0x0F {
+	case 0x0: // LOCAL
+		h.Local = true
+		return h, n, nil
+	case 0x1: // PROXY
+	default:
+		return nil, 0, ErrProxyHeader
+	}
+
+	var addrLen int
+	switch fam >> 4 {
+	case 0x0: // AF_UNSPEC
+		h.Local = true
+	case 0x1: // AF_INET
+		addrLen = 12
+		if len(payload) < addrLen {
+			return nil, 0, ErrProxyHeader
+		}
+		h.SourceAddr = net.JoinHostPort(net.IP(payload[0:4]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(payload[8:10]))))
+		h.DestAddr = net.JoinHostPort(net.IP(payload[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(payload[10:12]))))
+	case 0x2: // AF_INET6
+		addrLen = 36
+		if len(payload) < addrLen {
+			return nil, 0, ErrProxyHeader
+		}
+		h.SourceAddr = net.JoinHostPort(net.IP(payload[0:16]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(payload[32:34]))))
+		h.DestAddr = net.JoinHostPort(net.IP(payload[16:32]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(payload[34:36]))))
+	case 0x3: // AF_UNIX
+		addrLen = 216
+		if len(payload) < addrLen {
+			return nil, 0, ErrProxyHeader
+		}
+		h.SourceAddr = string(bytes.TrimRight(payload[0:108], "\x00"))
+		h.DestAddr = string(bytes.TrimRight(payload[108:216], "\x00"))
+	default:
+		return nil, 0, ErrProxyHeader
+	}
+
+	tlvs, err := parseProxyTLVs(payload[addrLen:])
+	if err != nil {
+		return nil, 0, err
+	}
+	h.TLVs = tlvs
+	return h, n, nil
+}
+
+func parseProxyTLVs(data []byte) (tlvs []ProxyTLV, err error) {
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, ErrProxyHeader
+		}
+		l := int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) < 3+l {
+			return nil, ErrProxyHeader
+		}
+		tlvs = append(tlvs, ProxyTLV{Type: data[0], Value: append([]byte(nil), data[3:3+l]...)})
+		data = data[3+l:]
+	}
+	return
+}
+
+// handleProxyHeader 累积并解析 PROXY 头，返回头之后的数据；头未收全或连接被关闭时 ok 为 false
+func (c *Connection) handleProxyHeader(data []byte) (rest []byte, ok bool) {
+	p := c.proxy
+	if len(p.buf) > 0 {
+		p.buf = append(p.buf, data...)
+		data = p.buf
+	}
+
+	h, n, err := parseProxyHeader(data)
+	if err != nil {
+		c.reportError(OpProtocol, err)
+		c.closeWith(CloseProtocolError, err)
+		return nil, false
+	}
+	if n == 0 {
+		if len(p.buf) == 0 {
+			p.buf = append(p.buf, data...)
+		}
+		return nil, false
+	}
+
+	c.proxy = nil
+	c.proxyHeader = h
+	if !h.Local {
+		c.peerAddr = h.SourceAddr
+		c.localAddr = h.DestAddr
+	}
+	p.onReady()
+	return data[n:], c.connected.Load()
+}
+
+// ProxyHeader 开启 PROXY protocol 时解析出的头，未开启时返回 nil
+func (c *Connection) ProxyHeader() *ProxyHeader {
+	return c.proxyHeader
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func sockaddrIP(sa unix.Sockaddr) net.IP {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return net.IP(sa.Addr[:])
+	case *unix.SockaddrInet6:
+		return net.IP(sa.Addr[:])
+	}
+	return nil
+}
+
+// defaultTrustedProxies TrustedProxies 为空时只信任本机的代理
+var defaultTrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+
+// trustedProxy 来源是否在 TrustedProxies 中，unix socket 总是可信
+func (s *Server) trustedProxy(sa unix.Sockaddr) bool {
+	if _, ok := sa.(*unix.SockaddrUnix); ok {
+		return true
+	}
+	ip := sockaddrIP(sa)
+	for _, n := range s.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
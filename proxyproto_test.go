@@ -0,0 +1,234 @@
+package goreaction
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func proxyV2Header(cmd, fam byte, addrs []byte, tlvs ...ProxyTLV) []byte {
+	payload := append([]byte(nil), addrs...)
+	for _, tlv := range tlvs {
+		payload = append(payload, tlv.Type, 0, 0)
+		binary.BigEndian.PutUint16(payload[len(payload)-2:], uint16(len(tlv.Value)))
+		payload = append(payload, tlv.Value...)
+	}
+	h := append(append([]byte(nil), proxyV2Sig...), 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(h[14:], uint16(len(payload)))
+	return append(h, payload...)
+}
+
+func TestParseProxyHeaderV1(t *testing.T) {
+	data := []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET /")
+	h, n, err := parseProxyHeader(data)
+	assert.Nil(t, err)
+	assert.Equal(t, "GET /", string(data[n:]))
+	assert.Equal(t, 1, h.Version)
+	assert.Equal(t, "192.168.0.1:56324", h.SourceAddr)
+	assert.Equal(t, "192.168.0.11:443", h.DestAddr)
+
+	h, _, err = parseProxyHeader([]byte("PROXY TCP6 ::1 2001:db8::1 1 2\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "[::1]:1", h.SourceAddr)
+
+	h, _, err = parseProxyHeader([]byte("PROXY UNKNOWN\r\n"))
+	assert.Nil(t, err)
+	assert.True(t, h.Local)
+
+	for _, partial := range []string{"PRO", "PROXY TCP4 192.168.0.1"} {
+		_, n, err = parseProxyHeader([]byte(partial))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+	}
+	for _, bad := range []string{"GET / HTTP/1.1\r\n", "PROXY TCP4 ::1 ::1 1 2\r\n", "PROXY TCP4 1.1.1.1 2.2.2.2 1\r\n"} {
+		_, _, err = parseProxyHeader([]byte(bad))
+		assert.Equal(t, ErrProxyHeader, err, bad)
+	}
+}
+
+func TestParseProxyHeaderV2(t *testing.T) {
+	addrs := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x1f, 0x90, 0x01, 0xbb}
+	data := proxyV2Header(0x1, 0x11, addrs,
+		ProxyTLV{Type: PP2TypeALPN, Value: []byte("h2")},
+		ProxyTLV{Type: PP2TypeAuthority, Value: []byte("example.com")})
+	data = append(data, "payload"...)
+
+	for i := 1; i < len(data)-len("payload"); i++ {
+		_, n, err := parseProxyHeader(data[:i])
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n, i)
+	}
+
+	h, n, err := parseProxyHeader(data)
+	assert.Nil(t, err)
+	assert.Equal(t, "payload", string(data[n:]))
+	assert.Equal(t, 2, h.Version)
+	assert.Equal(t, "10.0.0.1:8080", h.SourceAddr)
+	assert.Equal(t, "10.0.0.2:443", h.DestAddr)
+	assert.Equal(t, []ProxyTLV{
+		{Type: PP2TypeALPN, Value: []byte("h2")},
+		{Type: PP2TypeAuthority, Value: []byte("example.com")},
+	}, h.TLVs)
+
+	addrs6 := make([]byte, 36)
+	addrs6[15], addrs6[31], addrs6[33], addrs6[35] = 1, 2, 80, 81
+	h, _, err = parseProxyHeader(proxyV2Header(0x1, 0x21, addrs6))
+	assert.Nil(t, err)
+	assert.Equal(t, "[::1]:80", h.SourceAddr)
+	assert.Equal(t, "[::2]:81", h.DestAddr)
+
+	h, _, err = parseProxyHeader(proxyV2Header(0x0, 0x00, nil))
+	assert.Nil(t, err)
+	assert.True(t, h.Local)
+
+	_, _, err = parseProxyHeader(proxyV2Header(0x1, 0x11, addrs[:4]))
+	assert.Equal(t, ErrProxyHeader, err)
+	bad := proxyV2Header(0x1, 0x11, addrs)
+	bad = append(bad[:len(bad):len(bad)], 0x01)
+	binary.BigEndian.PutUint16(bad[14:], uint16(len(addrs)+1))
+	_, _, err = parseProxyHeader(bad)
+	assert.Equal(t, ErrProxyHeader, err)
+}
+
+type proxyExample struct {
+	serverTest
+	addrs chan [2]string
+}
+
+func (s *proxyExample) OnConnect(c *Connection) {
+	s.addrs <- [2]string{c.PeerAddr(), c.LocalAddr()}
+}
+
+func TestServer_ProxyProtocol(t *testing.T) {
+	handler := &proxyExample{addrs: make(chan [2]string, 1)}
+	s, err := NewServer(handler, Address("127.0.0.1:12358"), ProxyProtocol("127.0.0.0/8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12358")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 分两次发送，头被拆开
+	if _, err := conn.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 5678 ")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := conn.Write([]byte("443\r\nhello")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case addrs := <-handler.addrs:
+		assert.Equal(t, [2]string{"1.2.3.4:5678", "5.6.7.8:443"}, addrs)
+	case <-time.After(time.Second):
+		t.Fatal("OnConnect not called")
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hello", string(buf))
+}
+
+func TestServer_ProxyProtocolUntrusted(t *testing.T) {
+	handler := &proxyExample{addrs: make(chan [2]string, 1)}
+	s, err := NewServer(handler, Address("127.0.0.1:12359"), ProxyProtocol("10.0.0.0/8"),
+		OnError(func(err *OpError) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12359")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := conn.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatal(n, err)
+	}
+	assert.Equal(t, int64(0), handler.Count.Load())
+
+	if _, err := NewServer(handler, ProxyProtocol("bad cidr")); err == nil {
+		t.Fatal("invalid cidr should fail")
+	}
+}
+
+func TestServer_ProxyProtocolDefaultTrusted(t *testing.T) {
+	s, err := NewServer(new(serverTest), Address("127.0.0.1:12391"), ProxyProtocol())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = s.listener.Stop()
+		s.stopLoops()
+	}()
+	assert.True(t, s.trustedProxy(&unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}))
+	assert.True(t, s.trustedProxy(&unix.SockaddrInet6{Addr: [16]byte{15: 1}}))
+	assert.False(t, s.trustedProxy(&unix.SockaddrInet4{Addr: [4]byte{10, 0, 0, 1}}))
+}
+
+func TestServer_ProxyProtocolPerIP(t *testing.T) {
+	handler := &proxyExample{addrs: make(chan [2]string, 4)}
+	rejected := make(chan string, 1)
+	s, err := NewServer(handler, Address("127.0.0.1:12392"), ProxyProtocol("127.0.0.0/8"), MaxConnectionsPerIP(1),
+		OnReject(func(addr string, reason RejectReason) {
+			assert.Equal(t, RejectMaxConnectionsPerIP, reason)
+			rejected <- addr
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// 代理地址相同，按头中的来源限制
+	for _, src := range []string{"1.2.3.4 5.6.7.8 1000", "1.2.3.5 5.6.7.8 1001"} {
+		conn, err := net.Dial("tcp", "127.0.0.1:12392")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("PROXY TCP4 " + src + " 443\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-handler.addrs:
+		case <-time.After(time.Second):
+			t.Fatal("OnConnect not called")
+		}
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12392")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 1002 443\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case addr := <-rejected:
+		assert.Equal(t, "1.2.3.4:1002", addr)
+	case <-time.After(time.Second):
+		t.Fatal("connection should be rejected")
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := conn.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatal(n, err)
+	}
+}
@@ -0,0 +1,342 @@
+package goreaction
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"goreaction/poller"
+	"sync/atomic"
+)
+
+const (
+	// relayChunk 每次 splice 或复制的最大字节数，与默认管道容量相同
+	relayChunk = 1 << 16
+	// relayBudget 一轮最多转发的次数，超出后让出 loop
+	relayBudget = 16
+)
+
+var (
+	ErrRelayLoop = errors.New("relay connections must be on the same loop")
+	ErrRelayTLS  = errors.New("relay is not supported on TLS connections")
+	ErrRelaySelf = errors.New("relay connection to itself")
+	// ErrRelayCompletion 转发依赖就绪事件，不支持以 io_uring 完成事件收发的连接
+	ErrRelayCompletion = errors.New("relay is not supported on io_uring completion connections")
+)
+
+// Relay 两个连接之间的双向转发，数据经 splice(2) 通过管道在内核中转发，不经过 OnMessage。
+// splice 不可用时退回经 PacketBuf 的复制
+type Relay struct {
+	a, b *Connection
+	ab   *relayDir
+	ba   *relayDir
+	done bool
+}
+
+// relayDir 单向转发，管道中有数据时不再从 src 读取，dst 写不出时以此向 src 传递背压
+type relayDir struct {
+	src, dst *Connection
+	pipe     [2]int
+	spliced  atomic.Bool
+	buffered int
+	eof      bool
+	shut     bool
+	bytes    atomic.Int64
+}
+
+// NewRelay 在 a、b 之间双向转发数据，可在任意 goroutine 中调用。
+// a、b 需在同一个 loop 上，出站连接可用 DialSameLoop 与入站连接放在同一 loop。
+// 一端读到 EOF 且数据转发完后对另一端 SHUT_WR，两个方向都结束或任一端出错、关闭时关闭两个连接。
+// 转发开始前未被 Protocol 取走的数据会先转发，之后不要再对 a、b 调用 Send
+func NewRelay(a, b *Connection) (*Relay, error) {
+	return newRelay(a, b, true)
+}
+
+func newRelay(a, b *Connection, splice bool) (*Relay, error) {
+	if a == b {
+		return nil, ErrRelaySelf
+	}
+	if a.loop != b.loop {
+		return nil, ErrRelayLoop
+	}
+	if a.tls != nil || b.tls != nil {
+		return nil, ErrRelayTLS
+	}
+	if a.completion != nil || b.completion != nil {
+		return nil, ErrRelayCompletion
+	}
+
+	r := &Relay{
+		a:  a,
+		b:  b,
+		ab: &relayDir{src: a, dst: b, pipe: [2]int{-1, -1}},
+		ba: &relayDir{src: b, dst: a, pipe: [2]int{-1, -1}},
+	}
+	r.ab.spliced.Store(splice)
+	r.ba.spliced.Store(splice)
+	a.loop.QueueInLoop(r.start)
+	return r, nil
+}
+
+// Counters 已转发到对端的字节数
+func (r *Relay) Counters() (aToB, bToA int64) {
+	return r.ab.bytes.Load(), r.ba.bytes.Load()
+}
+
+// Spliced 两个方向是否都在使用 splice
+func (r *Relay) Spliced() bool {
+	return r.ab.spliced.Load() && r.ba.spliced.Load()
+}
+
+func (r *Relay) start() {
+	if !r.a.connected.Load() || !r.b.connected.Load() {
+		r.a.closeWith(CloseLocal, nil)
+		r.b.closeWith(CloseLocal, nil)
+		return
+	}
+	r.a.relay = r
+	r.b.relay = r
+
+	for _, d := range []*relayDir{r.ab, r.ba} {
+		if d.spliced.Load() {
+			if err := unix.Pipe2(d.pipe[:], unix.O_NONBLOCK|unix.O_CLOEXEC); err != nil {
+				d.spliced.Store(false)
+			}
+		}
+		// 未被 Protocol 取走的数据
+		if !d.src.inBuf.IsEmpty() {
+			ft, ed := d.src.inBuf.PeekAll()
+			n := len(ft) + len(ed)
+			if d.dst.sendvInLoop([][]byte{ft, ed}) {
+				return
+			}
+			d.src.inBuf.RetrieveAll()
+			d.bytes.Add(int64(n))
+		}
+	}
+	r.pump()
+}
+
+// handleEvent 转发中的连接的事件回调
+func (r *Relay) handleEvent(c *Connection, events poller.Event) {
+	if events&poller.EventErr != 0 {
+		r.handleHangup(c)
+		return
+	}
+	if events&poller.EventWrite != 0 && c.writePending() {
+		if _, err := c.writeOut(c.fd); err != nil && err != unix.EAGAIN {
+			c.closeWith(CloseWriteError, err)
+			return
+		}
+	}
+	r.pump()
+}
+
+// handleHangup 未关注读事件时 epoll 对已关闭的对端只报告 HUP，接收缓冲区中可能还有数据，
+// 连同管道中的数据一起读入另一端的 outBuf 后关闭 c，另一端写完后关闭
+func (r *Relay) handleHangup(c *Connection) {
+	soErr, err := unix.GetsockoptInt(c.fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err == nil && soErr != 0 {
+		err = unix.Errno(soErr)
+	}
+	if err != nil {
+		c.closeWith(ClosePollError, err)
+		return
+	}
+
+	d := r.ab
+	if c == r.b {
+		d = r.ba
+	}
+	buf := c.loop.PacketBuf()
+	for d.buffered > 0 {
+		n, err := unix.Read(d.pipe[0], buf)
+		if n <= 0 || err != nil {
+			break
+		}
+		d.dst.outBuf.Write(buf[:n])
+		d.buffered -= n
+		d.bytes.Add(int64(n))
+	}
+	for !d.eof {
+		n, err := unix.Read(c.fd, buf)
+		if n <= 0 || err != nil {
+			break
+		}
+		d.dst.outBuf.Write(buf[:n])
+		d.bytes.Add(int64(n))
+	}
+	d.eof = true
+	c.closeWith(ClosePeerEOF, nil)
+}
+
+// pump 转发两个方向的数据并更新关注的事件
+func (r *Relay) pump() {
+	yield := false
+	for _, d := range []*relayDir{r.ab, r.ba} {
+		more, ok := d.pump()
+		if !ok || r.done {
+			return
+		}
+		yield = yield || more
+	}
+
+	if r.ab.shut && r.ba.shut {
+		r.a.closeWith(ClosePeerEOF, nil)
+		return
+	}
+	if yield {
+		r.a.loop.QueueInLoop(func() {
+			if !r.done {
+				r.pump()
+			}
+		})
+	}
+	if !r.a.edgeTriggered && !r.updateInterest(r.a) {
+		return
+	}
+	if !r.b.edgeTriggered {
+		r.updateInterest(r.b)
+	}
+}
+
+// updateInterest 水平触发时按两个方向的状态设置 c 关注的事件
+func (r *Relay) updateInterest(c *Connection) (ok bool) {
+	in, out := r.ab, r.ba
+	if c == r.b {
+		in, out = r.ba, r.ab
+	}
+	read := !in.eof && in.buffered == 0 && !in.dst.writePending()
+	write := c.writePending() || out.buffered > 0
+
+	var err error
+	switch {
+	case read && write:
+		err = c.loop.EnableReadWrite(c.fd)
+	case read:
+		err = c.loop.EnableRead(c.fd)
+	case write:
+		err = c.loop.EnableWrite(c.fd)
+	default:
+		err = c.loop.DisableRead(c.fd)
+	}
+	if err != nil {
+		c.reportError(OpWrite, err)
+		c.closeWith(CloseWriteError, err)
+		return false
+	}
+	return true
+}
+
+// pump 转发到 EAGAIN、EOF 或用完 relayBudget，more 表示还有数据需要在之后继续转发
+func (d *relayDir) pump() (more, ok bool) {
+	for i := 0; i < relayBudget; i++ {
+		if d.dst.writePending() {
+			// 等待 dst 的 outBuf 写完
+			return false, true
+		}
+		if d.buffered > 0 {
+			n, err := unix.Splice(d.pipe[0], nil, d.dst.fd, nil, d.buffered, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+			if err == unix.EAGAIN {
+				return false, true
+			}
+			if err != nil {
+				d.dst.closeWith(CloseWriteError, err)
+				return false, false
+			}
+			d.buffered -= int(n)
+			d.bytes.Add(int64(n))
+			d.dst.touchWrite()
+			continue
+		}
+		if d.eof {
+			if !d.shut {
+				d.shut = true
+				if err := unix.Shutdown(d.dst.fd, unix.SHUT_WR); err != nil {
+					d.dst.closeWith(CloseWriteError, err)
+					return false, false
+				}
+			}
+			return false, true
+		}
+
+		n, err := d.read()
+		if err == unix.EAGAIN {
+			return false, true
+		}
+		if err != nil {
+			d.src.closeWith(CloseReadError, err)
+			return false, false
+		}
+		if n == 0 {
+			d.eof = true
+			continue
+		}
+		d.src.touchRead()
+		if !d.spliced.Load() {
+			d.bytes.Add(int64(n))
+			if d.dst.sendInLoop(d.src.loop.PacketBuf()[:n]) {
+				return false, false
+			}
+		}
+	}
+	return true, true
+}
+
+// read 从 src 读入管道，splice 不支持该 socket 时退回读入 PacketBuf
+func (d *relayDir) read() (int, error) {
+	if d.spliced.Load() {
+		n, err := unix.Splice(d.src.fd, nil, d.pipe[1], nil, relayChunk, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+		if err != unix.EINVAL && err != unix.ENOSYS {
+			if n > 0 {
+				d.buffered += int(n)
+			}
+			return int(n), err
+		}
+		d.spliced.Store(false)
+		d.closePipe()
+	}
+
+	buf := d.src.loop.PacketBuf()
+	if len(buf) > relayChunk {
+		buf = buf[:relayChunk]
+	}
+	n, err := unix.Read(d.src.fd, buf)
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+func (d *relayDir) closePipe() {
+	for i, fd := range d.pipe {
+		if fd >= 0 {
+			_ = unix.Close(fd)
+			d.pipe[i] = -1
+		}
+	}
+}
+
+// release c 关闭时结束转发，c 正常结束时另一端写完 outBuf 后关闭，否则立即关闭，只能在 loop 中调用
+func (r *Relay) release(c *Connection) {
+	if r.done {
+		return
+	}
+	r.done = true
+	r.ab.closePipe()
+	r.ba.closePipe()
+
+	peer := r.a
+	if c == r.a {
+		peer = r.b
+	}
+	if reason, _ := c.CloseReason(); reason != ClosePeerEOF {
+		peer.closeWith(CloseLocal, nil)
+		return
+	}
+	peer.closeAfterFlush(ClosePeerEOF)
+	if peer.connected.Load() && !peer.edgeTriggered {
+		if err := peer.updateInterest(); err != nil {
+			peer.reportError(OpWrite, err)
+			peer.closeWith(CloseWriteError, err)
+		}
+	}
+}
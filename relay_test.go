@@ -0,0 +1,130 @@
+package goreaction
+
+import (
+	"bytes"
+	"fmt"
+	"goreaction/eventloop"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+type relayFrontend struct {
+	serverTest
+	s       *Server
+	backend string
+	splice  bool
+	relays  chan *Relay
+}
+
+func (h *relayFrontend) OnConnect(c *Connection) {
+	_, err := h.s.Dial("tcp", h.backend, &relayBackend{front: h, in: c}, DialSameLoop(c))
+	if err != nil {
+		panic(err)
+	}
+}
+
+type relayBackend struct {
+	serverTest
+	front *relayFrontend
+	in    *Connection
+}
+
+func (h *relayBackend) OnConnect(c *Connection) {
+	r, err := newRelay(h.in, c, h.front.splice)
+	if err != nil {
+		panic(err)
+	}
+	h.front.relays <- r
+}
+
+func (h *relayBackend) OnClose(c *Connection) {}
+
+// echoUntilEOF 读到 EOF 后写回全部数据并关闭
+func echoUntilEOF(t *testing.T, ln net.Listener) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, _ = conn.Write(data)
+}
+
+func TestRelay(t *testing.T) {
+	data := make([]byte, 4<<20)
+	rand.Read(data)
+
+	for i, splice := range []bool{true, false} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go echoUntilEOF(t, ln)
+
+		addr := fmt.Sprintf("127.0.0.1:%d", 12385+i)
+		handler := &relayFrontend{backend: ln.Addr().String(), splice: splice, relays: make(chan *Relay, 1)}
+		s, err := NewServer(handler, Address(addr), NumLoops(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.s = s
+		go s.Start()
+		time.Sleep(100 * time.Millisecond)
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r *Relay
+		select {
+		case r = <-handler.relays:
+		case <-time.After(time.Second):
+			t.Fatal("relay not started")
+		}
+		if r.Spliced() != splice {
+			t.Fatalf("spliced should be %v", splice)
+		}
+
+		go func() {
+			_, _ = conn.Write(data)
+			_ = conn.(*net.TCPConn).CloseWrite()
+		}()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		got, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("relayed data mismatch, got %d bytes", len(got))
+		}
+		if aToB, bToA := r.Counters(); aToB != int64(len(data)) || bToA != int64(len(data)) {
+			t.Fatalf("counters should be %d, but %d %d", len(data), aToB, bToA)
+		}
+
+		_ = conn.Close()
+		_ = ln.Close()
+		s.Stop()
+	}
+}
+
+func TestRelayLoop(t *testing.T) {
+	l1, _ := eventloop.New()
+	l2, _ := eventloop.New()
+	defer l1.Stop()
+	defer l2.Stop()
+	a := &Connection{loop: l1}
+	b := &Connection{loop: l2}
+	if _, err := NewRelay(a, b); err != ErrRelayLoop {
+		t.Fatalf("error should be ErrRelayLoop, but %v", err)
+	}
+	if _, err := NewRelay(a, a); err != ErrRelaySelf {
+		t.Fatalf("error should be ErrRelaySelf, but %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package goreaction
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+)
+
+// maxSendFileChunk 单次 sendfile 的最大字节数
+const maxSendFileChunk = 1 << 30
+
+var (
+	ErrSendFileTLS        = errors.New("sendfile is not supported on TLS connections")
+	ErrSendFileCompletion = errors.New("sendfile is not supported on io_uring completion connections")
+)
+
+// SendFileHandler Handler 可选实现，SendFile 的文件发送完成或失败时在 loop 中回调，
+// 回调之前不能关闭 f
+type SendFileHandler interface {
+	OnSendFile(c *Connection, f *os.File, err error)
+}
+
+// fileSend 排队中的 SendFile，pre 为 outBuf 中排在它之前、尚未写出的字节数
+type fileSend struct {
+	file   *os.File
+	offset int64
+	remain int64
+	pre    int
+}
+
+// SendFile 用 sendfile(2) 发送 f 中从 offset 开始的 length 字节，length <= 0 时发送到文件末尾。
+// 与 Send、SendV 按调用顺序写出，不经过 Protocol.Packet，完成后回调 SendFileHandler.OnSendFile
+func (c *Connection) SendFile(f *os.File, offset, length int64) error {
+	if !c.connected.Load() {
+		return ErrConnectionClosed
+	}
+	if c.shutWr.Load() {
+		return ErrWriteShutdown
+	}
+	if c.tls != nil {
+		return ErrSendFileTLS
+	}
+	if c.completion != nil {
+		return ErrSendFileCompletion
+	}
+	if c.aboveHighWater() {
+		return ErrWouldBlock
+	}
+	if length <= 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		length = fi.Size() - offset
+	}
+
+	c.enqueue(pendingSend{file: &fileSend{file: f, offset: offset, remain: length}})
+	return nil
+}
+
+// writePending outBuf 或文件队列中是否还有待写数据
+func (c *Connection) writePending() bool {
+	return !c.outBuf.IsEmpty() || len(c.sendFiles) > 0
+}
+
+// queueFile 文件加入发送队列，前面没有待写数据时立即开始发送
+func (c *Connection) queueFile(f *fileSend) (closed bool) {
+	f.pre = c.outBuf.Length()
+	for _, q := range c.sendFiles {
+		f.pre -= q.pre
+	}
+	c.sendFiles = append(c.sendFiles, f)
+	if len(c.sendFiles) > 1 || !c.outBuf.IsEmpty() {
+		// 已在等待写事件
+		return
+	}
+
+	all, err := c.writeOut(c.fd)
+	if err != nil && err != unix.EAGAIN {
+		c.closeWith(CloseWriteError, err)
+		return true
+	}
+	if !all && !c.edgeTriggered {
+		if err := c.updateInterest(); err != nil {
+			c.reportError(OpWrite, err)
+			c.closeWith(CloseWriteError, err)
+			return true
+		}
+	}
+	return !c.connected.Load()
+}
+
+// writeOut 按顺序写出 outBuf 和文件队列，返回是否全部写完
+func (c *Connection) writeOut(fd int) (all bool, err error) {
+	for len(c.sendFiles) > 0 {
+		f := c.sendFiles[0]
+		if f.pre > 0 {
+			n, all, err := c.writeOutBuf(fd, f.pre)
+			f.pre -= n
+			if err != nil || !all {
+				return false, err
+			}
+		}
+
+		for f.remain > 0 {
+			size := f.remain
+			if size > maxSendFileChunk {
+				size = maxSendFileChunk
+			}
+			n, err := unix.Sendfile(fd, int(f.file.Fd()), &f.offset, int(size))
+			if n > 0 {
+				f.remain -= int64(n)
+				c.touchWrite()
+			}
+			if err != nil {
+				return false, err
+			}
+			if n == 0 {
+				// 文件比 length 短，之后的数据无法保证顺序
+				return false, io.ErrUnexpectedEOF
+			}
+			if int64(n) < size {
+				return false, nil
+			}
+		}
+		c.sendFiles[0] = nil
+		c.sendFiles = c.sendFiles[1:]
+		c.fileDone(f, nil)
+		if !c.connected.Load() {
+			return false, nil
+		}
+	}
+
+	if c.outBuf.IsEmpty() {
+		return true, nil
+	}
+	_, all, err = c.writeOutBuf(fd, -1)
+	return all, err
+}
+
+func (c *Connection) fileDone(f *fileSend, err error) {
+	if c.fileHook != nil {
+		c.fileHook.OnSendFile(c, f.file, err)
+	}
+}
+
+// failFiles 连接关闭时回调未发送完的文件
+func (c *Connection) failFiles() {
+	files := c.sendFiles
+	c.sendFiles = nil
+	for _, f := range files {
+		c.fileDone(f, ErrConnectionClosed)
+	}
+}
@@ -0,0 +1,93 @@
+package goreaction
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+type sendFileExample struct {
+	serverTest
+	file *os.File
+	done chan error
+}
+
+func (s *sendFileExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	_ = c.Send([]byte("head:"))
+	if err := c.SendFile(s.file, 0, 0); err != nil {
+		panic(err)
+	}
+	_ = c.Send([]byte(":mid:"))
+	if err := c.SendFile(s.file, 100, 1000); err != nil {
+		panic(err)
+	}
+	_ = c.Send([]byte(":end"))
+	return
+}
+
+func (s *sendFileExample) OnSendFile(c *Connection, f *os.File, err error) {
+	s.done <- err
+}
+
+func TestConnSendFile(t *testing.T) {
+	content := make([]byte, 8<<20)
+	rand.Read(content)
+	f, err := os.CreateTemp(t.TempDir(), "sendfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	want := append([]byte("head:"), content...)
+	want = append(want, ":mid:"...)
+	want = append(want, content[100:1100]...)
+	want = append(want, ":end"...)
+
+	for i, opts := range [][]Option{nil, {EdgeTriggered(0)}} {
+		addr := fmt.Sprintf("127.0.0.1:%d", 12383+i)
+		handler := &sendFileExample{file: f, done: make(chan error, 2)}
+		s, err := NewServer(handler, append(opts, Address(addr), NumLoops(1))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go s.Start()
+		time.Sleep(100 * time.Millisecond)
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write([]byte("get")); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(want))
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatal("data mismatch")
+		}
+		for j := 0; j < 2; j++ {
+			select {
+			case err := <-handler.done:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("OnSendFile not called")
+			}
+		}
+
+		_ = conn.Close()
+		s.Stop()
+	}
+}
@@ -1,11 +1,13 @@
 package goreaction
 
 import (
+	"context"
 	"errors"
-	"github.com/RussellLuo/timingwheel"
+	"fmt"
 	"golang.org/x/sys/unix"
 	"goreaction/eventloop"
-	"log"
+	"goreaction/utils/timingwheel"
+	"net"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -17,22 +19,47 @@ type Handler interface {
 	OnConnect(c *Connection)
 }
 
+// ShutdownHandler Handler 可选实现，Server.Shutdown 时在连接所属的 loop 中回调，
+// 可在此发送告别消息，消息会在连接关闭前写出
+type ShutdownHandler interface {
+	OnShutdown(c *Connection)
+}
+
+var (
+	ErrServerClosed             = errors.New("server closed")
+	ErrEdgeTriggeredUnsupported = errors.New("poller backend does not support edge-triggered mode")
+)
+
+// ShutdownError Shutdown 超时后强制关闭了仍未完成写出的连接
+type ShutdownError struct {
+	ForceClosed int
+	Err         error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("shutdown: %d connections force closed: %v", e.ForceClosed, e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+const shutdownPollInterval = 10 * time.Millisecond
+
 type Server struct {
 	listener  *listener
+	acceptors []*listener
 	workLoops []*eventloop.EventLoop
 	callback  Handler
 
 	timingWheel *timingwheel.TimingWheel
 	opts        *Options
 	running     atomic.Bool
-}
-
-type scheduler struct {
-	interval time.Duration
-}
+	closed      atomic.Bool
 
-func (s *scheduler) Next(prev time.Time) time.Time {
-	return prev.Add(s.interval)
+	trustedProxies []*net.IPNet
+	admission      *admission
+	workers        *workerPool
 }
 
 func NewServer(handler Handler, opts ...Option) (server *Server, err error) {
@@ -40,68 +67,201 @@ func NewServer(handler Handler, opts ...Option) (server *Server, err error) {
 		return nil, errors.New("handler is nil")
 	}
 	options := newOptions(opts...)
+	if options.WriteBufferHighWater > 0 && options.WriteBufferLowWater >= options.WriteBufferHighWater {
+		return nil, errWriteBufferLimit
+	}
 	server = new(Server)
 	server.callback = handler
 	server.opts = options
-	server.timingWheel = timingwheel.NewTimingWheel(server.opts.tick, server.opts.wheelSize)
-	server.listener, err = newListener(server.opts.Network, server.opts.Address, options.ReusePort, server.handleNewConnection)
-
-	if err != nil {
+	trusted := options.TrustedProxies
+	if options.ProxyProtocol && len(trusted) == 0 {
+		trusted = defaultTrustedProxies
+	}
+	if server.trustedProxies, err = parseCIDRs(trusted); err != nil {
 		return nil, err
 	}
+	server.admission = newAdmission(options.MaxConnections, options.MaxConnectionsPerIP)
+	server.timingWheel = timingwheel.New(server.opts.tick, server.opts.wheelSize)
+	if !server.opts.MultiAcceptor {
+		server.listener, err = newListener(server.opts, server.handleNewConnection)
+
+		if err != nil {
+			return nil, err
+		}
+	}
 	if server.opts.NumLoops <= 0 {
 		server.opts.NumLoops = runtime.NumCPU()
 	}
 
 	wloops := make([]*eventloop.EventLoop, server.opts.NumLoops)
 	for i := 0; i < server.opts.NumLoops; i++ {
-		l, err := eventloop.New()
+		l, err := eventloop.NewWithBackend(server.opts.Poller)
 		if err != nil {
 			for j := 0; j < i; j++ {
 				wloops[j].Stop()
 			}
 			return nil, err
 		}
+		l.SetErrorHandler(server.loopErrorHandler)
 		wloops[i] = l
 	}
+	if options.EdgeTriggered && !wloops[0].EdgeTriggered() {
+		for _, l := range wloops {
+			_ = l.Stop()
+		}
+		if server.listener != nil {
+			_ = server.listener.Close()
+			_ = server.listener.Stop()
+		}
+		return nil, ErrEdgeTriggeredUnsupported
+	}
+	if server.opts.MultiAcceptor {
+		server.acceptors, err = newReusePortListeners(server.opts, wloops, server.acceptInLoop)
+		if err != nil {
+			for _, l := range wloops {
+				_ = l.Stop()
+			}
+			return nil, err
+		}
+	}
 	server.workLoops = wloops
+	if options.Workers > 0 {
+		server.workers = newWorkerPool(options.Workers, options.WorkerQueueSize, options.WorkerQueuePolicy)
+	}
 	return
 }
 
+// RunAfter 回调在时间轮的 goroutine 中执行，需要与连接的回调串行时使用 Connection.RunAfter
 func (s *Server) RunAfter(d time.Duration, f func()) *timingwheel.Timer {
 	return s.timingWheel.AfterFunc(d, f)
 }
 
 func (s *Server) RunEvery(d time.Duration, f func()) *timingwheel.Timer {
-	return s.timingWheel.ScheduleFunc(&scheduler{d}, f)
+	return s.timingWheel.EveryFunc(d, f)
 }
 
 func (s *Server) handleNewConnection(fd int, sa unix.Sockaddr) {
-	loadBalance := RoundRobin()
-	loop := loadBalance(s.workLoops)
-	c := NewConnection(fd, loop, sa, s.opts.Protocol, s.timingWheel, s.opts.IdleTime, s.callback)
+	ip, ok := s.admit(fd, sa)
+	if !ok {
+		return
+	}
+	loop := s.opts.LoadBalancer.Next(s.workLoops, sa)
+	loop.QueueInLoop(s.newConnection(fd, sa, ip, loop))
+}
+
+// acceptInLoop 多 acceptor 模式下新连接直接注册到 accept 所在的 loop
+func (s *Server) acceptInLoop(loop *eventloop.EventLoop) handleConnFunc {
+	return func(fd int, sa unix.Sockaddr) {
+		ip, ok := s.admit(fd, sa)
+		if !ok {
+			return
+		}
+		s.newConnection(fd, sa, ip, loop)()
+	}
+}
+
+// admit 检查 PROXY 来源和连接数限制，未通过时关闭 fd
+func (s *Server) admit(fd int, sa unix.Sockaddr) (ip string, ok bool) {
+	if s.opts.ProxyProtocol && !s.trustedProxy(sa) {
+		_ = unix.Close(fd)
+		s.opts.ErrorHandler(&OpError{Op: OpAccept, Err: fmt.Errorf("%w: %s", ErrUntrustedProxy, sockAddrToString(sa))})
+		return "", false
+	}
 
-	loop.QueueInLoop(func() {
-		s.callback.OnConnect(c)
-		if err := loop.AddSocketAndEnableRead(fd, c); err != nil {
-			log.Fatal("[AddSocketAndEnableRead]", err)
+	if !s.opts.ProxyProtocol {
+		// PROXY 连接的来源是代理，单 IP 限制在解析头之后按真实来源检查，见 admitProxied
+		ip = admissionKey(sa)
+	}
+	if reason, ok := s.admission.acquire(ip); !ok {
+		s.reject(fd, sa, reason)
+		return "", false
+	}
+	return ip, true
+}
+
+// newConnection 创建连接，返回的函数需在 loop 中调用以注册连接
+func (s *Server) newConnection(fd int, sa unix.Sockaddr, ip string, loop *eventloop.EventLoop) func() {
+	c := NewConnection(fd, loop, sa, s.opts, s.callback)
+	var proxiedIP string
+	c.onRelease = func() {
+		s.admission.release(ip)
+		s.admission.releaseIP(proxiedIP)
+	}
+	s.useWorker(c)
+	if s.opts.TLSConfig != nil {
+		c.enableTLS(s.opts.TLSConfig)
+	}
+	if s.opts.ProxyProtocol {
+		c.proxy = &proxyState{onReady: func() {
+			var ok bool
+			if proxiedIP, ok = s.admitProxied(c); ok {
+				s.connectionReady(c)
+			}
+		}}
+	}
+	// PROXY 头解析、TLS 握手完成后才回调 OnConnect
+	deferred := c.proxy != nil || c.tls != nil
+
+	return func() {
+		if !deferred {
+			c.notifyConnect(s.callback)
+		}
+		var err error
+		switch {
+		case c.completion != nil:
+			err = loop.AddSocketCompletion(fd, c)
+		case s.opts.EdgeTriggered:
+			c.edgeTriggered, err = loop.AddSocketEdgeTriggered(fd, c)
+		default:
+			err = loop.AddSocketAndEnableRead(fd, c)
+		}
+		if err != nil {
+			s.opts.ErrorHandler(&OpError{Op: OpRegister, Conn: c, Err: err})
+			c.connected.Store(false)
+			if c.opened {
+				c.callback.OnClose(c)
+			}
+			_ = unix.Close(fd)
+			c.releaseResources()
+			return
 		}
-	})
+		if c.completion != nil {
+			c.startCompletion()
+		}
+		if deferred && c.proxy == nil {
+			s.connectionReady(c)
+		}
+	}
 }
 
-func RoundRobin() func([]*eventloop.EventLoop) *eventloop.EventLoop {
-	var nextLoopIndex int
+// useWorker 异步模式下为连接分配 worker
+func (s *Server) useWorker(c *Connection) {
+	if s.workers != nil {
+		c.pool = s.workers
+		c.worker = s.workers.assign()
+	}
+}
 
-	return func(loops []*eventloop.EventLoop) *eventloop.EventLoop {
-		l := loops[nextLoopIndex]
-		nextLoopIndex = (nextLoopIndex + 1) % len(loops)
-		return l
+func (s *Server) connectionReady(c *Connection) {
+	if c.tls != nil {
+		c.startTLSHandshake(s.opts.TLSHandshakeTimeout, func() {
+			c.notifyConnect(s.callback)
+		})
+		return
 	}
+	c.notifyConnect(s.callback)
+}
+
+func (s *Server) loopErrorHandler(err error) {
+	s.opts.ErrorHandler(&OpError{Op: OpLoop, Err: err})
 }
 
 func (s *Server) Start() {
 	wg := new(sync.WaitGroup)
 	s.timingWheel.Start()
+	if s.workers != nil {
+		s.workers.start()
+	}
 
 	l := len(s.workLoops)
 	for i := 0; i < l; i++ {
@@ -112,11 +272,13 @@ func (s *Server) Start() {
 		}(i)
 	}
 
-	wg.Add(1)
-	go func() {
-		s.listener.Run()
-		wg.Done()
-	}()
+	if s.listener != nil {
+		wg.Add(1)
+		go func() {
+			s.listener.Run()
+			wg.Done()
+		}()
+	}
 
 	s.running.Store(true)
 	wg.Wait()
@@ -125,19 +287,142 @@ func (s *Server) Start() {
 func (s *Server) Stop() {
 	if s.running.Load() {
 		s.running.Store(false)
+		s.closed.Store(true)
 		s.timingWheel.Stop()
-		if err := s.listener.Stop(); err != nil {
-			log.Fatal(err)
+		if s.listener != nil {
+			if err := s.listener.Stop(); err != nil {
+				s.loopErrorHandler(err)
+			}
 		}
 
+		// 在 loop 停止前关闭连接，OnClose 中的 CloseReason 为 CloseServerStop
+		s.forceCloseConnections()
+		s.stopWorkers()
 		for i := range s.workLoops {
 			if err := s.workLoops[i].Stop(); err != nil {
-				log.Fatal(err)
+				s.loopErrorHandler(err)
 			}
 		}
 	}
 }
 
+// Shutdown 停止 accept，通知 ShutdownHandler，等待各连接的 outBuf 写完后关闭连接；
+// ctx 结束时强制关闭剩余连接并返回 *ShutdownError
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !s.running.CompareAndSwap(true, false) {
+		return ErrServerClosed
+	}
+	s.closed.Store(true)
+	if s.listener != nil {
+		if err := s.listener.Stop(); err != nil {
+			return err
+		}
+	}
+
+	for i, l := range s.workLoops {
+		loop := l
+		var acceptor *listener
+		if s.acceptors != nil {
+			acceptor = s.acceptors[i]
+		}
+		loop.QueueInLoop(func() {
+			if acceptor != nil {
+				if err := loop.RemoveListener(acceptor.fd); err != nil {
+					s.loopErrorHandler(err)
+				}
+				_ = acceptor.Close()
+			}
+			loop.ForEachSocket(func(fd int, sock eventloop.Socket) {
+				switch sock := sock.(type) {
+				case *Connection:
+					sock.shutdownInLoop()
+				case *pendingConn:
+					sock.finish(ErrServerClosed)
+				}
+			})
+		})
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for s.connectionCount() > 0 {
+		select {
+		case <-ctx.Done():
+			forced := s.forceCloseConnections()
+			s.stopLoops()
+			return &ShutdownError{ForceClosed: forced, Err: ctx.Err()}
+		case <-ticker.C:
+		}
+	}
+
+	s.stopLoops()
+	return nil
+}
+
+func (s *Server) connectionCount() (n int64) {
+	for _, l := range s.workLoops {
+		n += l.ConnectionCount()
+	}
+	return
+}
+
+func (s *Server) forceCloseConnections() int {
+	var (
+		forced atomic.Int64
+		wg     sync.WaitGroup
+	)
+	for _, l := range s.workLoops {
+		loop := l
+		wg.Add(1)
+		loop.QueueInLoop(func() {
+			loop.ForEachSocket(func(fd int, sock eventloop.Socket) {
+				if c, ok := sock.(*Connection); ok {
+					c.closeWith(CloseServerStop, nil)
+					forced.Add(1)
+				}
+			})
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	return int(forced.Load())
+}
+
+func (s *Server) stopLoops() {
+	s.timingWheel.Stop()
+	s.stopWorkers()
+	for i := range s.workLoops {
+		_ = s.workLoops[i].Stop()
+	}
+}
+
+// stopWorkers 等 worker 处理完已分发的消息，再等各 loop 执行完推迟的 OnClose，须在 loop 停止前调用
+func (s *Server) stopWorkers() {
+	if s.workers == nil {
+		return
+	}
+	s.workers.stop()
+
+	var wg sync.WaitGroup
+	for _, l := range s.workLoops {
+		wg.Add(1)
+		// taskDone 排入的 workerIdle 先于此任务执行
+		l.QueueInLoop(wg.Done)
+	}
+	wg.Wait()
+}
+
+// FilteredCount 被 OnAccept hook 拒绝的连接数
+func (s *Server) FilteredCount() (n uint64) {
+	if s.listener != nil {
+		return s.listener.filtered.Load()
+	}
+	for _, l := range s.acceptors {
+		n += l.filtered.Load()
+	}
+	return
+}
+
 func (s *Server) Options() Options {
 	return *s.opts
 }
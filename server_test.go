@@ -2,11 +2,15 @@ package goreaction
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"goreaction/poller"
 	"io"
 	"log"
 	"math/rand"
 	"net"
+	"os"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -197,3 +201,328 @@ func TestServer_Stop(t *testing.T) {
 
 	s.Stop()
 }
+
+type shutdownTest struct {
+	serverTest
+	bye []byte
+}
+
+func (s *shutdownTest) OnShutdown(c *Connection) {
+	if err := c.Send(s.bye); err != nil {
+		panic(err)
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	handler := &shutdownTest{bye: []byte("bye")}
+
+	s, err := NewServer(handler, Address("127.0.0.1:12346"), NumLoops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12346")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "bye" {
+		t.Fatalf("expect bye, got %q", data)
+	}
+	if err := s.Shutdown(ctx); err != ErrServerClosed {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_ShutdownForceClose(t *testing.T) {
+	// 客户端不读取，outBuf 无法写完
+	handler := &shutdownTest{bye: make([]byte, 64*1024*1024)}
+
+	s, err := NewServer(handler, Address("127.0.0.1:12347"), NumLoops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12347")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = s.Shutdown(ctx)
+	var se *ShutdownError
+	if !errors.As(err, &se) {
+		t.Fatalf("expect ShutdownError, got %v", err)
+	}
+	if se.ForceClosed != 1 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_MultiAcceptor(t *testing.T) {
+	handler := new(serverTest)
+
+	s, err := NewServer(handler, Address("127.0.0.1:12363"), NumLoops(4), MultiAcceptor(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	conns := make([]net.Conn, 0, 32)
+	for i := 0; i < 32; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:12363")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "ping" {
+			t.Fatalf("reply should be ping, but %q", buf)
+		}
+		conns = append(conns, conn)
+	}
+
+	// 监听 socket 不计入连接数，连接由内核分配到多个 loop
+	used := 0
+	for _, l := range s.workLoops {
+		if l.ConnectionCount() > 0 {
+			used++
+		}
+	}
+	if s.connectionCount() != 32 || used < 2 {
+		t.Fatalf("connections should spread over loops, total %d, loops used %d", s.connectionCount(), used)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.Dial("tcp", "127.0.0.1:12363"); err == nil {
+		t.Fatal("dial should fail after shutdown")
+	}
+	for _, conn := range conns {
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("read should be EOF, but %v", err)
+		}
+	}
+}
+
+func TestServer_MultiAcceptorUnix(t *testing.T) {
+	_, err := NewServer(new(serverTest), Network("unix"), Address(t.TempDir()+"/goreaction.sock"), MultiAcceptor(true))
+	if err == nil {
+		t.Fatal("multi acceptor on unix socket should fail")
+	}
+}
+
+// benchmarkAccept 每次迭代新建连接并完成一次 echo
+func benchmarkAccept(b *testing.B, addr string, opts ...Option) {
+	s, err := NewServer(new(serverTest), append(opts, Address(addr), NumLoops(4))...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		buf := make([]byte, 4)
+		for pb.Next() {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			_, err = conn.Write([]byte("ping"))
+			if err == nil {
+				_, err = io.ReadFull(conn, buf)
+			}
+			_ = conn.Close()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkAccept_MainReactor(b *testing.B) {
+	benchmarkAccept(b, "127.0.0.1:12364")
+}
+
+func BenchmarkAccept_MultiAcceptor(b *testing.B) {
+	benchmarkAccept(b, "127.0.0.1:12365", MultiAcceptor(true))
+}
+
+func TestServer_IOUring(t *testing.T) {
+	handler := new(serverTest)
+
+	s, err := NewServer(handler, Address("127.0.0.1:12366"), NumLoops(2), PollerBackend(poller.BackendIOUring))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12366")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 超过一次读取的数据量，验证 poll 重新提交后仍能读到剩余数据
+	msg := make([]byte, 256*1024)
+	rand.Read(msg)
+	go func() {
+		_, _ = conn.Write(msg)
+	}()
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != string(msg) {
+		t.Fatal("echo mismatch")
+	}
+
+	_ = conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	if n := handler.Count.Load(); n != 0 {
+		t.Fatalf("connection should be closed, but %d", n)
+	}
+}
+
+func TestServer_IOUringEdgeTriggered(t *testing.T) {
+	p, err := poller.NewIOUring()
+	if err != nil {
+		t.Skip("io_uring not supported: ", err)
+	}
+	_ = p.Close()
+
+	_, err = NewServer(new(serverTest), Address("127.0.0.1:12399"), NumLoops(2),
+		PollerBackend(poller.BackendIOUring), EdgeTriggered(0))
+	if err != ErrEdgeTriggeredUnsupported {
+		t.Fatalf("error should be ErrEdgeTriggeredUnsupported, but %v", err)
+	}
+	// 监听 socket 已关闭，可以再次监听
+	ln, err := net.Listen("tcp", "127.0.0.1:12399")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = ln.Close()
+}
+
+// iouringTest 记录建立的连接，供测试在 loop 外操作
+type iouringTest struct {
+	serverTest
+	conns chan *Connection
+}
+
+func (s *iouringTest) OnConnect(c *Connection) {
+	s.serverTest.OnConnect(c)
+	s.conns <- c
+}
+
+func TestServer_IOUringCompletion(t *testing.T) {
+	p, err := poller.NewIOUring()
+	if err != nil {
+		t.Skip("io_uring not supported: ", err)
+	}
+	_, ok := p.(poller.Completion)
+	_ = p.Close()
+	if !ok {
+		t.Skip("io_uring completion not supported")
+	}
+
+	handler := &iouringTest{conns: make(chan *Connection, 2)}
+	s, err := NewServer(handler, Address("127.0.0.1:12402"), NumLoops(1), PollerBackend(poller.BackendIOUring))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	var clients [2]net.Conn
+	var conns [2]*Connection
+	for i := range clients {
+		if clients[i], err = net.Dial("tcp", "127.0.0.1:12402"); err != nil {
+			t.Fatal(err)
+		}
+		defer clients[i].Close()
+		select {
+		case conns[i] = <-handler.conns:
+		case <-time.After(time.Second):
+			t.Fatal("OnConnect timeout")
+		}
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "sendfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := conns[0].SendFile(f, 0, 0); err != ErrSendFileCompletion {
+		t.Fatalf("error should be ErrSendFileCompletion, but %v", err)
+	}
+	if _, err := NewRelay(conns[0], conns[1]); err != ErrRelayCompletion {
+		t.Fatalf("error should be ErrRelayCompletion, but %v", err)
+	}
+
+	// 暂停读取期间不回复，恢复后处理暂停前已提交的 recv 读到的数据
+	if err := conns[0].PauseRead(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := clients[0].Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	_ = clients[0].SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := clients[0].Read(buf); err == nil {
+		t.Fatalf("read should time out while paused, but %q", buf[:n])
+	}
+	if err := conns[0].ResumeRead(); err != nil {
+		t.Fatal(err)
+	}
+	_ = clients[0].SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(clients[0], buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("reply should be ping, but %q %v", buf, err)
+	}
+
+	// Send 之后立即 Close，已提交的 sendmsg 写完后才关闭 fd
+	if err := conns[1].Send([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conns[1].Close()
+	_ = clients[1].SetReadDeadline(time.Now().Add(time.Second))
+	data, err := io.ReadAll(clients[1])
+	if err != nil || string(data) != "bye" {
+		t.Fatalf("peer should read bye then EOF, but %q %v", data, err)
+	}
+}
@@ -0,0 +1,57 @@
+package goreaction
+
+import (
+	"goreaction/eventloop"
+	"time"
+)
+
+// RunAfter d 之后在连接所属的 loop 中执行 f，可在任意 goroutine 中调用，连接关闭时自动取消
+func (c *Connection) RunAfter(d time.Duration, f func()) *eventloop.Timer {
+	c.timerMu.Lock()
+	defer c.timerMu.Unlock()
+
+	var t *eventloop.Timer
+	t = c.loop.RunAfter(d, func() {
+		c.timerMu.Lock()
+		delete(c.timers, t)
+		c.timerMu.Unlock()
+		f()
+	})
+	c.trackTimer(t)
+	return t
+}
+
+// RunEvery 每隔 d 在连接所属的 loop 中执行一次 f，可在任意 goroutine 中调用，连接关闭时自动取消
+func (c *Connection) RunEvery(d time.Duration, f func()) *eventloop.Timer {
+	c.timerMu.Lock()
+	defer c.timerMu.Unlock()
+
+	t := c.loop.RunEvery(d, f)
+	c.trackTimer(t)
+	return t
+}
+
+// trackTimer 需持有 timerMu
+func (c *Connection) trackTimer(t *eventloop.Timer) {
+	if c.timersClosed {
+		t.Stop()
+		return
+	}
+	if c.timers == nil {
+		c.timers = make(map[*eventloop.Timer]struct{})
+	}
+	c.timers[t] = struct{}{}
+}
+
+// stopTimers 取消 RunAfter、RunEvery 创建的定时器
+func (c *Connection) stopTimers() {
+	c.timerMu.Lock()
+	timers := c.timers
+	c.timers = nil
+	c.timersClosed = true
+	c.timerMu.Unlock()
+
+	for t := range timers {
+		t.Stop()
+	}
+}
@@ -0,0 +1,66 @@
+package goreaction
+
+import (
+	"goreaction/eventloop"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type timerExample struct {
+	serverTest
+	ticks  atomic.Int64
+	timers chan *eventloop.Timer
+	closed chan struct{}
+}
+
+func (s *timerExample) OnConnect(c *Connection) {
+	s.timers <- c.RunEvery(10*time.Millisecond, func() {
+		s.ticks.Add(1)
+	})
+	s.timers <- c.RunAfter(time.Hour, func() {})
+	// 回调在 loop 中执行，可以直接关闭连接
+	c.RunAfter(50*time.Millisecond, func() {
+		c.closeWith(CloseLocal, nil)
+	})
+}
+
+func (s *timerExample) OnClose(c *Connection) {
+	close(s.closed)
+}
+
+func TestConnRunAfter(t *testing.T) {
+	handler := &timerExample{timers: make(chan *eventloop.Timer, 2), closed: make(chan struct{})}
+	s, err := NewServer(handler, Address("127.0.0.1:12387"), NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12387")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case <-handler.closed:
+	case <-time.After(time.Second):
+		t.Fatal("RunAfter callback not fired")
+	}
+	every, after := <-handler.timers, <-handler.timers
+	if !every.Stopped() || !after.Stopped() {
+		t.Fatal("timers should be stopped when connection closed")
+	}
+	ticks := handler.ticks.Load()
+	if ticks == 0 {
+		t.Fatal("RunEvery callback not fired")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := handler.ticks.Load(); n != ticks {
+		t.Fatalf("RunEvery should stop after close, but ticks %d -> %d", ticks, n)
+	}
+}
@@ -0,0 +1,275 @@
+package goreaction
+
+import (
+	"crypto/tls"
+	"errors"
+	"golang.org/x/sys/unix"
+	"goreaction/eventloop"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultTLSHandshakeTimeout 未设置 TLSHandshakeTimeout 时的握手超时
+const DefaultTLSHandshakeTimeout = 10 * time.Second
+
+var ErrTLSHandshakeTimeout = errors.New("tls: handshake timeout")
+
+// errWouldBlock 握手完成后密文不足一个 record 时返回，crypto/tls 不会因临时错误失效
+var errWouldBlock error = wouldBlockError{}
+
+type wouldBlockError struct{}
+
+func (wouldBlockError) Error() string   { return "tls: would block" }
+func (wouldBlockError) Timeout() bool   { return true }
+func (wouldBlockError) Temporary() bool { return true }
+
+// tlsBridge 作为 tls.Conn 的底层 net.Conn，密文来自 loop 读到的数据，写出走连接的 outBuf。
+// crypto/tls 的握手是同步的，每个握手中的连接占用一个 goroutine 阻塞读取，由 loop 的定时器限制握手时长；
+// 握手完成后只在 loop 中使用，读不到数据时返回 errWouldBlock
+type tlsBridge struct {
+	c *Connection
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	in          []byte
+	handshaking bool
+	closed      bool
+	// notify closeTLS 在 loop 中写出 close_notify 期间为 true
+	notify bool
+}
+
+func (b *tlsBridge) feed(data []byte) {
+	b.mu.Lock()
+	b.in = append(b.in, data...)
+	b.mu.Unlock()
+	b.cond.Signal()
+}
+
+func (b *tlsBridge) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.in) == 0 {
+		if b.closed {
+			return 0, io.EOF
+		}
+		if !b.handshaking {
+			return 0, errWouldBlock
+		}
+		b.cond.Wait()
+	}
+	n := copy(p, b.in)
+	b.in = b.in[n:]
+	if len(b.in) == 0 {
+		b.in = nil
+	}
+	return n, nil
+}
+
+func (b *tlsBridge) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	handshaking, closed, notify := b.handshaking, b.closed, b.notify
+	b.mu.Unlock()
+
+	c := b.c
+	switch {
+	case closed:
+		if !notify {
+			// 关闭后 fd 可能已被新连接复用，握手 goroutine 的写入直接丢弃
+			return 0, net.ErrClosed
+		}
+		// 尽力发送 close_notify，此时在 loop 中且 fd 尚未关闭
+		_, _ = unix.Write(c.fd, p)
+	case handshaking:
+		data := append([]byte(nil), p...)
+		c.loop.QueueInLoop(func() {
+			if c.connected.Load() {
+				c.sendInLoop(data)
+			}
+		})
+	default:
+		if c.sendInLoop(p) {
+			return 0, net.ErrClosed
+		}
+	}
+	return len(p), nil
+}
+
+func (b *tlsBridge) close(notify bool) {
+	b.mu.Lock()
+	b.closed = true
+	b.notify = notify
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+func (b *tlsBridge) Close() error                       { return nil }
+func (b *tlsBridge) LocalAddr() net.Addr                { return tlsAddr(b.c.localAddr) }
+func (b *tlsBridge) RemoteAddr() net.Addr               { return tlsAddr(b.c.peerAddr) }
+func (b *tlsBridge) SetDeadline(t time.Time) error      { return nil }
+func (b *tlsBridge) SetReadDeadline(t time.Time) error  { return nil }
+func (b *tlsBridge) SetWriteDeadline(t time.Time) error { return nil }
+
+type tlsAddr string
+
+func (a tlsAddr) Network() string { return "tcp" }
+func (a tlsAddr) String() string  { return string(a) }
+
+type tlsState struct {
+	bridge      *tlsBridge
+	conn        *tls.Conn
+	established bool
+	timer       *eventloop.Timer
+	pending     [][]byte
+	state       tls.ConnectionState
+}
+
+func (c *Connection) enableTLS(config *tls.Config) {
+	b := &tlsBridge{c: c, handshaking: true}
+	b.cond = sync.NewCond(&b.mu)
+	c.tls = &tlsState{
+		bridge: b,
+		conn:   tls.Server(b, config),
+	}
+}
+
+// startTLSHandshake 在独立 goroutine 中握手，完成后回到 loop 中回调 done，只能在 loop 中调用。
+// 超过 timeout 未完成时以 CloseProtocolError 关闭连接，唤醒握手 goroutine
+func (c *Connection) startTLSHandshake(timeout time.Duration, done func()) {
+	t := c.tls
+	t.timer = c.loop.RunAfter(timeout, func() {
+		if c.connected.Load() && !t.established {
+			c.reportError(OpTLS, ErrTLSHandshakeTimeout)
+			c.closeWith(CloseProtocolError, ErrTLSHandshakeTimeout)
+		}
+	})
+	go func() {
+		err := t.conn.Handshake()
+		c.loop.QueueInLoop(func() {
+			c.handshakeDone(err, done)
+		})
+	}()
+}
+
+func (c *Connection) handshakeDone(err error, done func()) {
+	if !c.connected.Load() {
+		return
+	}
+	c.tls.timer.Stop()
+	if err != nil {
+		c.reportError(OpTLS, err)
+		c.closeWith(CloseProtocolError, err)
+		return
+	}
+
+	t := c.tls
+	t.bridge.mu.Lock()
+	t.bridge.handshaking = false
+	t.bridge.mu.Unlock()
+	t.state = t.conn.ConnectionState()
+	t.established = true
+	done()
+
+	pending := t.pending
+	t.pending = nil
+	for _, data := range pending {
+		if c.writeTLS(data) {
+			return
+		}
+	}
+	// 握手期间客户端可能已经发送了应用数据
+	c.readTLS()
+}
+
+func (c *Connection) writeTLS(data []byte) (closed bool) {
+	t := c.tls
+	if !t.established {
+		t.pending = append(t.pending, append([]byte(nil), data...))
+		return
+	}
+	if _, err := t.conn.Write(data); err != nil {
+		if c.connected.Load() {
+			c.reportError(OpTLS, err)
+			c.closeWith(CloseWriteError, err)
+		}
+		return true
+	}
+	return !c.connected.Load()
+}
+
+func (c *Connection) handleTLSRead(data []byte) (closed bool) {
+	c.tls.bridge.feed(data)
+	if !c.tls.established {
+		return
+	}
+	return c.readTLS()
+}
+
+// readTLS 解密所有完整的 record 并交给 Protocol
+func (c *Connection) readTLS() (closed bool) {
+	buf := c.loop.PacketBuf()
+	for {
+		n, err := c.tls.conn.Read(buf)
+		if n > 0 {
+			_, _ = c.inBuf.Write(buf[:n])
+		}
+		if err != nil {
+			if errors.Is(err, errWouldBlock) {
+				break
+			}
+			if err == io.EOF {
+				c.closeWith(ClosePeerEOF, nil)
+				return true
+			}
+			c.reportError(OpTLS, err)
+			c.closeWith(CloseProtocolError, err)
+			return true
+		}
+	}
+
+	var out []byte
+	c.handlerProtocol(&out, c.inBuf)
+	if len(out) != 0 {
+		if c.writeTLS(out) {
+			return true
+		}
+	}
+	return c.checkReadBuffer()
+}
+
+// closeTLS 唤醒握手 goroutine，握手已完成时发送 close_notify
+func (c *Connection) closeTLS() {
+	b := c.tls.bridge
+	if c.tls.timer != nil {
+		c.tls.timer.Stop()
+	}
+	b.close(c.tls.established)
+	if c.tls.established {
+		_ = c.tls.conn.CloseWrite()
+		b.mu.Lock()
+		b.notify = false
+		b.mu.Unlock()
+	}
+}
+
+// TLSConnectionState 握手完成后的 TLS 状态，非 TLS 连接返回 false
+func (c *Connection) TLSConnectionState() (tls.ConnectionState, bool) {
+	if c.tls == nil || !c.tls.established {
+		return tls.ConnectionState{}, false
+	}
+	return c.tls.state, true
+}
+
+// NegotiatedProtocol ALPN 协商的协议
+func (c *Connection) NegotiatedProtocol() string {
+	state, _ := c.TLSConnectionState()
+	return state.NegotiatedProtocol
+}
+
+// ServerName 客户端通过 SNI 请求的域名
+func (c *Connection) ServerName() string {
+	state, _ := c.TLSConnectionState()
+	return state.ServerName
+}
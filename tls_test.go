@@ -0,0 +1,177 @@
+package goreaction
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+type tlsExample struct {
+	serverTest
+	states chan [2]string
+}
+
+func (s *tlsExample) OnConnect(c *Connection) {
+	s.states <- [2]string{c.NegotiatedProtocol(), c.ServerName()}
+}
+
+func TestServer_TLS(t *testing.T) {
+	handler := &tlsExample{states: make(chan [2]string, 1)}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t)},
+		NextProtos:   []string{"h2", "echo"},
+	}
+
+	s, err := NewServer(handler, Address("127.0.0.1:12356"), NumLoops(2), TLSConfig(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := tls.Dial("tcp", "127.0.0.1:12356", &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         "example.com",
+		NextProtos:         []string{"echo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case state := <-handler.states:
+		if state[0] != "echo" || state[1] != "example.com" {
+			t.Fatal(state)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnConnect not called")
+	}
+
+	for _, size := range []int{5, 16 * 1024, 1024 * 1024} {
+		data := make([]byte, size)
+		_, _ = rand.Read(data)
+		go func() {
+			_, _ = conn.Write(data)
+		}()
+		got := make([]byte, size)
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, got) {
+			t.Fatalf("mismatch, size %d", size)
+		}
+	}
+}
+
+func TestServer_TLSHandshakeError(t *testing.T) {
+	handler := &tlsExample{states: make(chan [2]string, 1)}
+	errs := make(chan *OpError, 1)
+	config := &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}}
+
+	s, err := NewServer(handler, Address("127.0.0.1:12357"), TLSConfig(config),
+		OnError(func(err *OpError) {
+			errs <- err
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12357")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-errs:
+		if e.Op != OpTLS {
+			t.Fatal(e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("error handler not called")
+	}
+	select {
+	case <-handler.states:
+		t.Fatal("OnConnect should not be called")
+	default:
+	}
+}
+
+func TestServer_TLSHandshakeTimeout(t *testing.T) {
+	handler := &tlsExample{states: make(chan [2]string, 1)}
+	errs := make(chan *OpError, 1)
+	config := &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}}
+
+	s, err := NewServer(handler, Address("127.0.0.1:12401"), TLSConfig(config),
+		TLSHandshakeTimeout(200*time.Millisecond),
+		OnError(func(err *OpError) {
+			errs <- err
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// 连接后不发送 ClientHello，超时后被关闭
+	conn, err := net.Dial("tcp", "127.0.0.1:12401")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	start := time.Now()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Fatalf("closed too early: %v", d)
+	}
+	select {
+	case e := <-errs:
+		if e.Op != OpTLS || e.Err != ErrTLSHandshakeTimeout {
+			t.Fatal(e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("error handler not called")
+	}
+}
@@ -0,0 +1,447 @@
+package goreaction
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/sys/unix"
+	"goreaction/eventloop"
+	"goreaction/poller"
+	"goreaction/utils"
+	"goreaction/utils/reuseport"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+var (
+	// DefaultPacketBatch 每次 recvmmsg/sendmmsg 处理的最大报文数
+	DefaultPacketBatch = 16
+	// MaxDatagramSize 单个报文的最大长度
+	MaxDatagramSize = 65536
+)
+
+// PacketHandler 报文回调，data 只在回调内有效
+type PacketHandler interface {
+	OnPacket(c *PacketConn, addr unix.Sockaddr, data []byte)
+}
+
+type packet struct {
+	addr unix.Sockaddr
+	data []byte
+}
+
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// PacketConn 绑定在 loop 上的数据报 socket
+type PacketConn struct {
+	fd        int
+	conn      net.PacketConn
+	loop      *eventloop.EventLoop
+	handler   PacketHandler
+	localAddr string
+	onError   ErrorHandler
+	closed    atomic.Bool
+
+	// recvmmsg 批量缓冲
+	rmsgs  []mmsghdr
+	riovs  []unix.Iovec
+	rnames []unix.RawSockaddrAny
+	rbufs  [][]byte
+
+	// sendmmsg 批量缓冲
+	mu           utils.SpinLock
+	pending      []packet
+	sending      []packet
+	smsgs        []mmsghdr
+	siovs        []unix.Iovec
+	snames       []unix.RawSockaddrAny
+	flushQueued  atomic.Bool
+	waitWritable bool
+}
+
+func newPacketConn(opts *Options, addr string, loop *eventloop.EventLoop, handler PacketHandler) (*PacketConn, error) {
+	var lc net.ListenConfig
+	if opts.ReusePort {
+		lc.Control = reuseport.Control
+	}
+	pc, err := lc.ListenPacket(context.Background(), opts.Network, addr)
+	if err != nil {
+		return nil, err
+	}
+	uc, ok := pc.(*net.UDPConn)
+	if !ok {
+		_ = pc.Close()
+		return nil, errors.New("could not get file descriptor")
+	}
+	file, err := uc.File()
+	if err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	// File 返回 dup 出的 fd，关闭 *os.File 前先 dup 一份自己管理
+	fd, err := unix.Dup(int(file.Fd()))
+	_ = file.Close()
+	if err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	if err = unix.SetNonblock(fd, true); err != nil {
+		_ = unix.Close(fd)
+		_ = pc.Close()
+		return nil, err
+	}
+
+	batch := DefaultPacketBatch
+	c := &PacketConn{
+		fd:        fd,
+		conn:      pc,
+		loop:      loop,
+		handler:   handler,
+		localAddr: pc.LocalAddr().String(),
+		onError:   opts.ErrorHandler,
+		rmsgs:     make([]mmsghdr, batch),
+		riovs:     make([]unix.Iovec, batch),
+		rnames:    make([]unix.RawSockaddrAny, batch),
+		rbufs:     make([][]byte, batch),
+		smsgs:     make([]mmsghdr, batch),
+		siovs:     make([]unix.Iovec, batch),
+		snames:    make([]unix.RawSockaddrAny, batch),
+	}
+	for i := 0; i < batch; i++ {
+		c.rbufs[i] = make([]byte, MaxDatagramSize)
+		c.riovs[i].Base = &c.rbufs[i][0]
+		c.riovs[i].SetLen(MaxDatagramSize)
+		c.rmsgs[i].hdr.Iov = &c.riovs[i]
+		c.rmsgs[i].hdr.SetIovlen(1)
+	}
+	return c, nil
+}
+
+func (c *PacketConn) LocalAddr() string {
+	return c.localAddr
+}
+
+// WriteTo 发送报文到 addr，任意 goroutine 可调用；data 在返回后即可复用。
+// 同一轮 loop 内的发送会合并为一次 sendmmsg
+func (c *PacketConn) WriteTo(data []byte, addr unix.Sockaddr) error {
+	if c.closed.Load() {
+		return ErrConnectionClosed
+	}
+	p := packet{addr: addr, data: append([]byte(nil), data...)}
+
+	c.mu.Lock()
+	c.pending = append(c.pending, p)
+	c.mu.Unlock()
+
+	if c.flushQueued.CompareAndSwap(false, true) {
+		c.loop.QueueInLoop(c.flush)
+	}
+	return nil
+}
+
+// Close 关闭 socket，任意 goroutine 可调用
+func (c *PacketConn) Close() error {
+	if c.closed.Load() {
+		return ErrConnectionClosed
+	}
+	c.loop.QueueInLoop(c.closeInLoop)
+	return nil
+}
+
+func (c *PacketConn) closeInLoop() {
+	if !c.closed.CompareAndSwap(false, true) {
+		return
+	}
+	if err := c.loop.DeleteFdInLoop(c.fd); err != nil {
+		c.reportError(OpClose, err)
+	}
+	if err := unix.Close(c.fd); err != nil {
+		c.reportError(OpClose, err)
+	}
+	_ = c.conn.Close()
+}
+
+func (c *PacketConn) reportError(op Op, err error) {
+	c.onError(&OpError{Op: op, Err: err})
+}
+
+// internal use, eventloop callback
+func (c *PacketConn) HandleEvent(fd int, events poller.Event) {
+	if events&poller.EventWrite != 0 {
+		if soErr, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR); err == nil && soErr != 0 {
+			c.reportError(OpWrite, unix.Errno(soErr))
+		}
+		if c.waitWritable {
+			c.flush()
+		}
+	}
+	if events&poller.EventRead != 0 {
+		c.handleRead(fd)
+	}
+}
+
+func (c *PacketConn) handleRead(fd int) {
+	batch := len(c.rmsgs)
+	for {
+		for i := 0; i < batch; i++ {
+			c.rmsgs[i].hdr.Name = (*byte)(unsafe.Pointer(&c.rnames[i]))
+			c.rmsgs[i].hdr.Namelen = unix.SizeofSockaddrAny
+		}
+		n, err := recvmmsg(fd, c.rmsgs)
+		if err != nil {
+			if err != unix.EAGAIN && err != unix.EINTR {
+				c.reportError(OpRead, err)
+			}
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			addr := rawToSockaddr(&c.rnames[i])
+			c.handler.OnPacket(c, addr, c.rbufs[i][:c.rmsgs[i].len])
+			if c.closed.Load() {
+				return
+			}
+		}
+		if n < batch {
+			return
+		}
+	}
+}
+
+func (c *PacketConn) flush() {
+	c.flushQueued.Store(false)
+	if c.closed.Load() {
+		return
+	}
+
+	c.mu.Lock()
+	c.sending = append(c.sending, c.pending...)
+	c.pending = c.pending[:0]
+	c.mu.Unlock()
+
+	for len(c.sending) > 0 {
+		n := len(c.sending)
+		if n > len(c.smsgs) {
+			n = len(c.smsgs)
+		}
+		for i := 0; i < n; i++ {
+			p := c.sending[i]
+			namelen, err := sockaddrToRaw(p.addr, &c.snames[i])
+			if err != nil {
+				c.reportError(OpWrite, err)
+				namelen = 0
+			}
+			if len(p.data) > 0 {
+				c.siovs[i].Base = &p.data[0]
+			} else {
+				c.siovs[i].Base = nil
+			}
+			c.siovs[i].SetLen(len(p.data))
+			c.smsgs[i].hdr.Name = (*byte)(unsafe.Pointer(&c.snames[i]))
+			c.smsgs[i].hdr.Namelen = namelen
+			c.smsgs[i].hdr.Iov = &c.siovs[i]
+			c.smsgs[i].hdr.SetIovlen(1)
+		}
+
+		sent, err := sendmmsg(c.fd, c.smsgs[:n])
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			if err == unix.EAGAIN {
+				c.enableWrite()
+				return
+			}
+			// 丢弃出错的报文，继续发送后面的
+			c.reportError(OpWrite, err)
+			sent = 1
+		}
+		c.sending = c.sending[sent:]
+	}
+	c.sending = c.sending[:0]
+
+	if c.waitWritable {
+		c.waitWritable = false
+		if err := c.loop.EnableRead(c.fd); err != nil {
+			c.reportError(OpWrite, err)
+		}
+	}
+}
+
+func (c *PacketConn) enableWrite() {
+	if c.waitWritable {
+		return
+	}
+	c.waitWritable = true
+	if err := c.loop.EnableReadWrite(c.fd); err != nil {
+		c.reportError(OpWrite, err)
+	}
+}
+
+func recvmmsg(fd int, msgs []mmsghdr) (int, error) {
+	n, _, errno := unix.Syscall6(unix.SYS_RECVMMSG, uintptr(fd),
+		uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), unix.MSG_DONTWAIT, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func sendmmsg(fd int, msgs []mmsghdr) (int, error) {
+	n, _, errno := unix.Syscall6(unix.SYS_SENDMMSG, uintptr(fd),
+		uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), unix.MSG_DONTWAIT, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func rawToSockaddr(rsa *unix.RawSockaddrAny) unix.Sockaddr {
+	switch rsa.Addr.Family {
+	case unix.AF_INET:
+		pp := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		sa := &unix.SockaddrInet4{Addr: pp.Addr}
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		sa.Port = int(p[0])<<8 + int(p[1])
+		return sa
+	case unix.AF_INET6:
+		pp := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		sa := &unix.SockaddrInet6{Addr: pp.Addr, ZoneId: pp.Scope_id}
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		sa.Port = int(p[0])<<8 + int(p[1])
+		return sa
+	}
+	return nil
+}
+
+func sockaddrToRaw(sa unix.Sockaddr, rsa *unix.RawSockaddrAny) (uint32, error) {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		pp := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		pp.Family = unix.AF_INET
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		pp.Addr = sa.Addr
+		return unix.SizeofSockaddrInet4, nil
+	case *unix.SockaddrInet6:
+		pp := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		pp.Family = unix.AF_INET6
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		pp.Flowinfo = 0
+		pp.Addr = sa.Addr
+		pp.Scope_id = sa.ZoneId
+		return unix.SizeofSockaddrInet6, nil
+	}
+	return 0, unix.EAFNOSUPPORT
+}
+
+// PacketServer 数据报服务，ReusePort 时每个 loop 绑定一个 SO_REUSEPORT socket，
+// 由内核分发报文，否则只使用一个 loop
+type PacketServer struct {
+	loops   []*eventloop.EventLoop
+	conns   []*PacketConn
+	handler PacketHandler
+	opts    *Options
+	running atomic.Bool
+}
+
+func NewPacketServer(handler PacketHandler, opts ...Option) (server *PacketServer, err error) {
+	if handler == nil {
+		return nil, errors.New("handler is nil")
+	}
+	options := newOptions(opts...)
+	switch options.Network {
+	case "udp", "udp4", "udp6":
+	case "tcp":
+		options.Network = "udp"
+	default:
+		return nil, errors.New("unsupported packet network: " + options.Network)
+	}
+
+	numLoops := 1
+	if options.ReusePort {
+		numLoops = options.NumLoops
+		if numLoops <= 0 {
+			numLoops = runtime.NumCPU()
+		}
+	}
+
+	server = &PacketServer{handler: handler, opts: options}
+	addr := options.Address
+	for i := 0; i < numLoops; i++ {
+		l, err := eventloop.NewWithBackend(options.Poller)
+		if err != nil {
+			server.release()
+			return nil, err
+		}
+		l.SetErrorHandler(func(err error) {
+			options.ErrorHandler(&OpError{Op: OpLoop, Err: err})
+		})
+		server.loops = append(server.loops, l)
+
+		c, err := newPacketConn(options, addr, l, handler)
+		if err != nil {
+			server.release()
+			return nil, err
+		}
+		// 端口为 0 时其余 socket 使用第一个 socket 分配到的端口
+		addr = c.localAddr
+		if err = l.AddSocketAndEnableRead(c.fd, c); err != nil {
+			_ = unix.Close(c.fd)
+			_ = c.conn.Close()
+			server.release()
+			return nil, err
+		}
+		server.conns = append(server.conns, c)
+	}
+	return
+}
+
+// release 释放未启动的 server 持有的资源
+func (s *PacketServer) release() {
+	for _, c := range s.conns {
+		_ = unix.Close(c.fd)
+		_ = c.conn.Close()
+	}
+	// loop 未运行，Stop 只关闭 poller 的 epoll fd 和 eventfd
+	for _, l := range s.loops {
+		_ = l.Stop()
+	}
+}
+
+// Conns 每个 loop 上绑定的 socket
+func (s *PacketServer) Conns() []*PacketConn {
+	return s.conns
+}
+
+func (s *PacketServer) Start() {
+	wg := new(sync.WaitGroup)
+	for i := range s.loops {
+		wg.Add(1)
+		go func(l *eventloop.EventLoop) {
+			l.Run()
+			wg.Done()
+		}(s.loops[i])
+	}
+
+	s.running.Store(true)
+	wg.Wait()
+}
+
+func (s *PacketServer) Stop() {
+	if s.running.CompareAndSwap(true, false) {
+		for i, l := range s.loops {
+			c := s.conns[i]
+			l.QueueInLoop(c.closeInLoop)
+			if err := l.Stop(); err != nil {
+				s.opts.ErrorHandler(&OpError{Op: OpLoop, Err: err})
+			}
+		}
+	}
+}
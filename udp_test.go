@@ -0,0 +1,101 @@
+package goreaction
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type udpEcho struct {
+	count atomic.Int64
+	// conns 收到过报文的 socket
+	conns sync.Map
+}
+
+func (s *udpEcho) OnPacket(c *PacketConn, addr unix.Sockaddr, data []byte) {
+	s.count.Add(1)
+	s.conns.Store(c, struct{}{})
+	if err := c.WriteTo(data, addr); err != nil {
+		panic(err)
+	}
+}
+
+// testPacketServer 返回收到过报文的 socket 数
+func testPacketServer(t *testing.T, addr string, opts ...Option) int {
+	handler := new(udpEcho)
+	s, err := NewPacketServer(handler, append([]Option{Network("udp"), Address(addr)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// 端口为 0 时所有 socket 绑定同一个地址
+	addr = s.Conns()[0].LocalAddr()
+	for _, c := range s.Conns() {
+		assert.Equal(t, addr, c.LocalAddr())
+	}
+
+	for i := 0; i < 8; i++ {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 32; j++ {
+			msg := fmt.Sprintf("packet %d-%d", i, j)
+			if _, err := conn.Write([]byte(msg)); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 64)
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, msg, string(buf[:n]))
+		}
+		_ = conn.Close()
+	}
+	assert.Equal(t, int64(8*32), handler.count.Load())
+
+	var n int
+	handler.conns.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func TestPacketServer(t *testing.T) {
+	testPacketServer(t, "127.0.0.1:12350")
+}
+
+func TestPacketServer_ReusePort(t *testing.T) {
+	// 内核按四元组在各 socket 间分配，8 个客户端全部落到同一个 socket 的概率可以忽略
+	n := testPacketServer(t, "127.0.0.1:12351", ReusePort(true), NumLoops(4))
+	assert.Greater(t, n, 1)
+}
+
+func TestPacketServer_ReusePortAnyPort(t *testing.T) {
+	n := testPacketServer(t, "127.0.0.1:0", ReusePort(true), NumLoops(4))
+	assert.Greater(t, n, 1)
+}
+
+func TestSockaddrRaw(t *testing.T) {
+	var rsa unix.RawSockaddrAny
+	for _, sa := range []unix.Sockaddr{
+		&unix.SockaddrInet4{Addr: [4]byte{10, 1, 2, 3}, Port: 5353},
+		&unix.SockaddrInet6{Addr: [16]byte{0: 0xfe, 1: 0x80, 15: 1}, Port: 443, ZoneId: 2},
+	} {
+		if _, err := sockaddrToRaw(sa, &rsa); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, sa, rawToSockaddr(&rsa))
+	}
+}
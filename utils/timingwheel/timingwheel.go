@@ -1 +1,287 @@
+// Package timingwheel 分层时间轮，添加、取消定时器为 O(1)，到期的定时器按 tick 批量执行
 package timingwheel
+
+import (
+	"sync"
+	"time"
+)
+
+// Timer 时间轮中的定时器
+type Timer struct {
+	tw         *TimingWheel
+	expiration int64
+	period     int64
+	f          func()
+
+	// 所在的槽，nil 表示不在时间轮中
+	bucket     *bucket
+	prev, next *Timer
+}
+
+// Stop 取消定时器，返回 false 表示定时器已到期或已取消。
+// 周期定时器可以在自己的回调中 Stop
+func (t *Timer) Stop() bool {
+	tw := t.tw
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	t.period = 0
+	if t.bucket == nil {
+		return false
+	}
+	t.bucket.remove(t)
+	tw.count--
+	return true
+}
+
+// Reset 以 d 重新设置到期时间，返回定时器重置前是否处于等待中。
+// 已到期或已取消的定时器也会重新加入
+func (t *Timer) Reset(d time.Duration) bool {
+	tw := t.tw
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	active := t.bucket != nil
+	if active {
+		t.bucket.remove(t)
+		tw.count--
+	}
+	t.expiration = tw.now() + tw.ticks(d)
+	tw.add(t)
+	return active
+}
+
+// bucket 时间轮的槽，定时器组成侵入式双向链表
+type bucket struct {
+	head *Timer
+}
+
+func (b *bucket) push(t *Timer) {
+	t.bucket = b
+	t.prev = nil
+	t.next = b.head
+	if b.head != nil {
+		b.head.prev = t
+	}
+	b.head = t
+}
+
+func (b *bucket) remove(t *Timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		b.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.bucket, t.prev, t.next = nil, nil, nil
+}
+
+// take 取出槽中所有定时器
+func (b *bucket) take() *Timer {
+	head := b.head
+	b.head = nil
+	return head
+}
+
+// wheel 一层时间轮，第 n 层每个槽的跨度为 tick*size^n
+type wheel struct {
+	span    int64
+	buckets []bucket
+}
+
+// TimingWheel 分层时间轮，第 0 层每个槽为一个 tick，超出当前最高层范围时按需创建上一层（溢出轮）。
+// 回调在时间轮的 goroutine 中执行，不能阻塞
+type TimingWheel struct {
+	tick  time.Duration
+	size  int64
+	start time.Time
+
+	mu      sync.Mutex
+	wheels  []*wheel
+	current int64
+	count   int
+
+	exitC chan struct{}
+	wg    sync.WaitGroup
+	once  sync.Once
+}
+
+// New 创建时间轮，tick 为精度，wheelSize 为每层的槽数
+func New(tick time.Duration, wheelSize int64) *TimingWheel {
+	if tick <= 0 {
+		panic("timingwheel: tick must be greater than 0")
+	}
+	if wheelSize < 2 {
+		panic("timingwheel: wheelSize must be at least 2")
+	}
+	tw := &TimingWheel{
+		tick:  tick,
+		size:  wheelSize,
+		start: time.Now(),
+		exitC: make(chan struct{}),
+	}
+	tw.wheels = []*wheel{tw.newWheel(1)}
+	return tw
+}
+
+func (tw *TimingWheel) newWheel(span int64) *wheel {
+	return &wheel{span: span, buckets: make([]bucket, tw.size)}
+}
+
+// now 从 New 开始经过的 tick 数，Start 之前 current 不前进，到期时间以此为准
+func (tw *TimingWheel) now() int64 {
+	return int64(time.Since(tw.start) / tw.tick)
+}
+
+// ticks d 对应的 tick 数，不足一个 tick 按一个 tick 计
+func (tw *TimingWheel) ticks(d time.Duration) int64 {
+	n := int64((d + tw.tick - 1) / tw.tick)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// AfterFunc d 之后执行 f
+func (tw *TimingWheel) AfterFunc(d time.Duration, f func()) *Timer {
+	return tw.schedule(d, 0, f)
+}
+
+// EveryFunc 每隔 d 执行一次 f，直到 Stop
+func (tw *TimingWheel) EveryFunc(d time.Duration, f func()) *Timer {
+	return tw.schedule(d, tw.ticks(d), f)
+}
+
+func (tw *TimingWheel) schedule(d time.Duration, period int64, f func()) *Timer {
+	t := &Timer{tw: tw, period: period, f: f}
+	tw.mu.Lock()
+	t.expiration = tw.now() + tw.ticks(d)
+	tw.add(t)
+	tw.mu.Unlock()
+	return t
+}
+
+// Len 等待中的定时器数量
+func (tw *TimingWheel) Len() int {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.count
+}
+
+// add 把定时器放入能容纳其剩余时间的最低一层，需持有 mu
+func (tw *TimingWheel) add(t *Timer) {
+	delta := t.expiration - tw.current
+	if delta < 1 {
+		// 级联时已到期的定时器放在下一个 tick
+		delta = 1
+		t.expiration = tw.current + 1
+	}
+	level := 0
+	for span := int64(1); delta >= span*tw.size; span *= tw.size {
+		level++
+		if level == len(tw.wheels) {
+			tw.wheels = append(tw.wheels, tw.newWheel(span*tw.size))
+		}
+	}
+	w := tw.wheels[level]
+	w.buckets[(t.expiration/w.span)%tw.size].push(t)
+	tw.count++
+}
+
+// advance 前进一个 tick，把到期的定时器挂到 expired 上，需持有 mu
+func (tw *TimingWheel) advance(expired *Timer) *Timer {
+	tw.current++
+	// 上层槽到达边界时把其中的定时器降级到下层
+	for level := 1; level < len(tw.wheels); level++ {
+		w := tw.wheels[level]
+		if tw.current%w.span != 0 {
+			break
+		}
+		for t := w.buckets[(tw.current/w.span)%tw.size].take(); t != nil; {
+			next := t.next
+			tw.count--
+			if t.expiration <= tw.current {
+				expired = detach(t, expired)
+			} else {
+				t.bucket, t.prev, t.next = nil, nil, nil
+				tw.add(t)
+			}
+			t = next
+		}
+	}
+
+	w := tw.wheels[0]
+	for t := w.buckets[tw.current%tw.size].take(); t != nil; {
+		next := t.next
+		tw.count--
+		expired = detach(t, expired)
+		t = next
+	}
+	return expired
+}
+
+// detach 把已从槽中取出的 t 挂到 expired 链表头
+func detach(t, expired *Timer) *Timer {
+	t.bucket, t.prev = nil, nil
+	t.next = expired
+	return t
+}
+
+// Start 启动驱动时间轮的 goroutine
+func (tw *TimingWheel) Start() {
+	tw.wg.Add(1)
+	go func() {
+		defer tw.wg.Done()
+		ticker := time.NewTicker(tw.tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				tw.expire(int64(now.Sub(tw.start) / tw.tick))
+			case <-tw.exitC:
+				return
+			}
+		}
+	}()
+}
+
+// expire 前进到 target，批量执行这段时间内到期的定时器
+func (tw *TimingWheel) expire(target int64) {
+	tw.mu.Lock()
+	var expired *Timer
+	for tw.current < target {
+		if tw.count == 0 {
+			// 没有定时器时直接跳到 target，如 Start 远晚于 New
+			tw.current = target
+			break
+		}
+		expired = tw.advance(expired)
+	}
+	// 周期定时器在执行前重新加入，回调中可以 Stop
+	var batch []func()
+	for t := expired; t != nil; {
+		next := t.next
+		t.next = nil
+		batch = append(batch, t.f)
+		if t.period > 0 {
+			t.expiration = tw.current + t.period
+			tw.add(t)
+		}
+		t = next
+	}
+	tw.mu.Unlock()
+
+	for _, f := range batch {
+		f()
+	}
+}
+
+// Stop 停止时间轮，未到期的定时器不再执行
+func (tw *TimingWheel) Stop() {
+	tw.once.Do(func() {
+		close(tw.exitC)
+	})
+	tw.wg.Wait()
+}
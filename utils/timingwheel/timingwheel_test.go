@@ -0,0 +1,163 @@
+package timingwheel
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimingWheel_Expire(t *testing.T) {
+	// tick 足够长，测试期间经过的 tick 数不变，由 expire 推进
+	tw := New(time.Minute, 4)
+
+	// 跨越多层溢出轮，每个定时器都应在对应的 tick 到期
+	fired := make(map[int64]int64)
+	var now int64
+	for _, d := range []int64{1, 3, 4, 5, 15, 16, 17, 63, 64, 65, 200, 1000} {
+		d := d
+		tw.AfterFunc(time.Duration(d)*time.Minute, func() {
+			fired[d] = now
+		})
+	}
+	if n := len(tw.wheels); n != 5 {
+		t.Fatalf("wheels should be 5, but %d", n)
+	}
+	for now = 1; now <= 1000; now++ {
+		tw.expire(now)
+	}
+	if len(fired) != 12 {
+		t.Fatalf("fired should be 12, but %d", len(fired))
+	}
+	for d, at := range fired {
+		if d != at {
+			t.Fatalf("timer %d fired at %d", d, at)
+		}
+	}
+	if n := tw.Len(); n != 0 {
+		t.Fatalf("len should be 0, but %d", n)
+	}
+}
+
+func TestTimingWheel_StopReset(t *testing.T) {
+	tw := New(time.Minute, 8)
+
+	var fired atomic.Int32
+	t1 := tw.AfterFunc(10*time.Minute, func() { fired.Add(1) })
+	t2 := tw.AfterFunc(100*time.Minute, func() { fired.Add(10) })
+	if !t1.Stop() {
+		t.Fatal("stop should return true")
+	}
+	if t1.Stop() {
+		t.Fatal("stop twice should return false")
+	}
+	if !t2.Reset(5 * time.Minute) {
+		t.Fatal("reset should return true")
+	}
+	tw.expire(5)
+	if n := fired.Load(); n != 10 {
+		t.Fatalf("fired should be 10, but %d", n)
+	}
+	if t2.Reset(time.Minute) {
+		t.Fatal("reset of expired timer should return false")
+	}
+	tw.expire(6)
+	if n := fired.Load(); n != 20 {
+		t.Fatalf("fired should be 20, but %d", n)
+	}
+}
+
+func TestTimingWheel_Every(t *testing.T) {
+	tw := New(time.Minute, 8)
+
+	var n int
+	var timer *Timer
+	timer = tw.EveryFunc(3*time.Minute, func() {
+		n++
+		if n == 5 {
+			timer.Stop()
+		}
+	})
+	for i := int64(1); i <= 100; i++ {
+		tw.expire(i)
+	}
+	if n != 5 {
+		t.Fatalf("n should be 5, but %d", n)
+	}
+	if l := tw.Len(); l != 0 {
+		t.Fatalf("len should be 0, but %d", l)
+	}
+}
+
+func TestTimingWheel_Start(t *testing.T) {
+	tw := New(time.Millisecond, 20)
+	tw.Start()
+	defer tw.Stop()
+
+	done := make(chan time.Duration, 1)
+	start := time.Now()
+	tw.AfterFunc(50*time.Millisecond, func() {
+		done <- time.Since(start)
+	})
+	select {
+	case d := <-done:
+		if d < 50*time.Millisecond {
+			t.Fatalf("fired too early: %v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timer not fired")
+	}
+}
+
+func TestTimingWheel_BeforeStart(t *testing.T) {
+	tw := New(time.Millisecond, 20)
+	time.Sleep(150 * time.Millisecond)
+
+	done := make(chan time.Duration, 1)
+	start := time.Now()
+	tw.AfterFunc(200*time.Millisecond, func() {
+		done <- time.Since(start)
+	})
+	tw.Start()
+	defer tw.Stop()
+	select {
+	case d := <-done:
+		if d < 200*time.Millisecond {
+			t.Fatalf("fired too early: %v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timer not fired")
+	}
+}
+
+// BenchmarkTimingWheel_IdleConns 百万连接的空闲定时器，每次操作相当于一次读事件后重置空闲超时
+func BenchmarkTimingWheel_IdleConns(b *testing.B) {
+	const conns = 1000000
+	tw := New(time.Millisecond, 1000)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	timers := make([]*Timer, conns)
+	for i := range timers {
+		timers[i] = tw.AfterFunc(time.Duration(60000+i%60000)*time.Millisecond, func() {})
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		timers[i%conns].Reset(60 * time.Second)
+		if i%1000 == 0 {
+			tw.expire(tw.current + 1)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/conns, "B/timer")
+
+	// 重置不会分配新的定时器，等待中的数量保持不变
+	if n := tw.Len(); n != conns {
+		b.Fatalf("len should be %d, but %d", conns, n)
+	}
+}
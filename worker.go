@@ -0,0 +1,201 @@
+package goreaction
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultWorkerQueueSize 异步模式下每个 worker 队列的默认长度
+const DefaultWorkerQueueSize = 1024
+
+// QueuePolicy 异步模式下 worker 队列满时的处理方式
+type QueuePolicy int
+
+const (
+	// QueueBlock 阻塞 loop 直到队列有空位
+	QueueBlock QueuePolicy = iota
+	// QueueDrop 丢弃消息并计入 WorkerStats.Dropped
+	QueueDrop
+	// QueueClose 丢弃消息并以 CloseBufferFull 关闭连接
+	QueueClose
+)
+
+func (p QueuePolicy) String() string {
+	switch p {
+	case QueueBlock:
+		return "block"
+	case QueueDrop:
+		return "drop"
+	case QueueClose:
+		return "close"
+	}
+	return "unknown"
+}
+
+var ErrWorkerQueueFull = errors.New("worker queue full")
+
+// WorkerStats 异步模式下 worker 队列的统计
+type WorkerStats struct {
+	Workers       int
+	QueueDepth    int
+	QueueCapacity int
+	Dropped       uint64
+}
+
+type workerTask struct {
+	c    *Connection
+	ctx  interface{}
+	data []byte
+}
+
+// workerPool 每个连接固定分配到一个 worker，队列 FIFO，保证同一连接的消息按顺序处理
+type workerPool struct {
+	queues  []chan workerTask
+	policy  QueuePolicy
+	next    atomic.Uint64
+	dropped atomic.Uint64
+	quit    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+func newWorkerPool(workers, queueSize int, policy QueuePolicy) *workerPool {
+	p := &workerPool{
+		queues: make([]chan workerTask, workers),
+		policy: policy,
+		quit:   make(chan struct{}),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan workerTask, queueSize)
+	}
+	return p
+}
+
+func (p *workerPool) start() {
+	for _, q := range p.queues {
+		p.wg.Add(1)
+		go p.run(q)
+	}
+}
+
+// stop 通知 worker 处理完队列中剩余的消息后退出，并等待退出
+func (p *workerPool) stop() {
+	p.once.Do(func() {
+		close(p.quit)
+	})
+	p.wg.Wait()
+}
+
+// assign 轮询为新连接分配 worker 队列
+func (p *workerPool) assign() chan workerTask {
+	return p.queues[p.next.Add(1)%uint64(len(p.queues))]
+}
+
+func (p *workerPool) run(q chan workerTask) {
+	defer p.wg.Done()
+	for {
+		select {
+		case t := <-q:
+			p.handle(t)
+		case <-p.quit:
+			// 队列中的消息也要 taskDone，否则推迟的 OnClose 不会回调
+			for {
+				select {
+				case t := <-q:
+					p.handle(t)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *workerPool) handle(t workerTask) {
+	if t.c.connected.Load() {
+		if out := t.c.callback.OnMessage(t.c, t.ctx, t.data); out != nil {
+			t.c.queueWrite(out)
+		}
+	}
+	t.c.taskDone()
+}
+
+func (p *workerPool) stats() WorkerStats {
+	s := WorkerStats{Workers: len(p.queues), Dropped: p.dropped.Load()}
+	for _, q := range p.queues {
+		s.QueueDepth += len(q)
+		s.QueueCapacity += cap(q)
+	}
+	return s
+}
+
+// dispatch 把解码后的消息交给连接所属的 worker，只能在 loop 中调用。
+// data 可能引用 loop 的缓冲区，需要复制
+func (c *Connection) dispatch(ctx interface{}, data []byte) {
+	t := workerTask{c: c, ctx: ctx, data: append([]byte(nil), data...)}
+	p := c.pool
+	c.inflight.Add(1)
+	if p.policy == QueueBlock {
+		select {
+		case c.worker <- t:
+		case <-p.quit:
+			c.taskDone()
+		}
+		return
+	}
+
+	select {
+	case <-p.quit:
+		c.taskDone()
+		return
+	default:
+	}
+	select {
+	case c.worker <- t:
+		return
+	default:
+	}
+	c.taskDone()
+	p.dropped.Add(1)
+	if p.policy == QueueClose {
+		c.reportError(OpProtocol, ErrWorkerQueueFull)
+		// handlerProtocol 之后还会访问缓冲区，不能在这里同步关闭
+		_ = c.closeAsync(CloseBufferFull, ErrWorkerQueueFull)
+	}
+}
+
+// taskDone 一条消息处理完或未能分发，最后一条完成时回到 loop 中处理等待中的关闭
+func (c *Connection) taskDone() {
+	if c.inflight.Add(-1) == 0 {
+		c.loop.QueueInLoop(c.workerIdle)
+	}
+}
+
+// workerIdle 已分发的消息都处理完后回调推迟的 OnClose，或关闭等待 drain 的连接
+func (c *Connection) workerIdle() {
+	if c.inflight.Load() > 0 {
+		return
+	}
+	if c.closeNotify {
+		c.closeNotify = false
+		c.callback.OnClose(c)
+		return
+	}
+	if c.draining && c.connected.Load() && !c.writePending() {
+		c.closeDrained()
+	}
+}
+
+// drainPending 关闭前还有数据要写出或消息在 worker 中处理
+func (c *Connection) drainPending() bool {
+	return c.writePending() || c.inflight.Load() > 0
+}
+
+// WorkerStats 异步模式下 worker 队列的深度、容量及丢弃的消息数，未开启时返回零值
+func (s *Server) WorkerStats() WorkerStats {
+	if s.workers == nil {
+		return WorkerStats{}
+	}
+	return s.workers.stats()
+}
@@ -0,0 +1,261 @@
+package goreaction
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type asyncExample struct {
+	serverTest
+	release chan struct{}
+}
+
+func (s *asyncExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	if strings.HasPrefix(string(data), "slow") {
+		<-s.release
+	}
+	return append([]byte{}, data...)
+}
+
+func TestAsyncHandler(t *testing.T) {
+	handler := &asyncExample{release: make(chan struct{})}
+	s, err := NewServer(handler, Address("127.0.0.1:12379"), NumLoops(1),
+		CustomProtocol(&lineProtocol{}), AsyncHandler(2, 0, QueueBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	slow, err := net.Dial("tcp", "127.0.0.1:12379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	fast, err := net.Dial("tcp", "127.0.0.1:12379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fast.Close()
+
+	var msgs strings.Builder
+	msgs.WriteString("slow\n")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&msgs, "%d\n", i)
+	}
+	if _, err := slow.Write([]byte(msgs.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一 loop 上的其他连接不受阻塞的 OnMessage 影响
+	if _, err := fast.Write([]byte("fast\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = fast.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := bufio.NewReader(fast).ReadString('\n')
+	if err != nil || line != "fast\n" {
+		t.Fatalf("read should be fast, but %q %v", line, err)
+	}
+	if stats := s.WorkerStats(); stats.Workers != 2 || stats.QueueCapacity != 2*DefaultWorkerQueueSize {
+		t.Fatalf("unexpected worker stats %+v", stats)
+	}
+
+	close(handler.release)
+	_ = slow.SetReadDeadline(time.Now().Add(time.Second))
+	r := bufio.NewReader(slow)
+	if line, err := r.ReadString('\n'); err != nil || line != "slow\n" {
+		t.Fatalf("read should be slow, but %q %v", line, err)
+	}
+	for i := 0; i < 100; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil || line != fmt.Sprintf("%d\n", i) {
+			t.Fatalf("reply %d out of order: %q %v", i, line, err)
+		}
+	}
+}
+
+func TestAsyncHandlerQueueFull(t *testing.T) {
+	for i, policy := range []QueuePolicy{QueueDrop, QueueClose} {
+		addr := fmt.Sprintf("127.0.0.1:%d", 12380+i)
+		handler := &asyncExample{release: make(chan struct{})}
+		s, err := NewServer(handler, Address(addr), NumLoops(1),
+			CustomProtocol(&lineProtocol{}), AsyncHandler(1, 1, policy), OnError(func(err *OpError) {}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go s.Start()
+		time.Sleep(100 * time.Millisecond)
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// worker 阻塞在第一条消息上，队列只能再放一条
+		if _, err := conn.Write([]byte("slow\n")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if _, err := conn.Write([]byte("a\nb\nc\n")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		stats := s.WorkerStats()
+		if stats.QueueDepth != 1 || stats.Dropped != 2 {
+			t.Fatalf("%v: depth should be 1 and dropped 2, but %+v", policy, stats)
+		}
+		close(handler.release)
+
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		var got []string
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			got = append(got, line)
+		}
+		switch policy {
+		case QueueDrop:
+			if strings.Join(got, "") != "slow\na\n" {
+				t.Fatalf("drop: replies should be slow and a, but %q", got)
+			}
+		case QueueClose:
+			if len(got) != 0 {
+				t.Fatalf("close: connection should be closed without replies, but %q", got)
+			}
+		}
+
+		_ = conn.Close()
+		s.Stop()
+	}
+}
+
+type asyncSlowExample struct {
+	serverTest
+	busy    atomic.Bool
+	overlap atomic.Bool
+	closed  chan struct{}
+}
+
+func (s *asyncSlowExample) OnMessage(c *Connection, ctx interface{}, data []byte) (out interface{}) {
+	s.busy.Store(true)
+	time.Sleep(300 * time.Millisecond)
+	s.busy.Store(false)
+	return []byte("pong")
+}
+
+func (s *asyncSlowExample) OnClose(c *Connection) {
+	s.overlap.Store(s.busy.Load())
+	close(s.closed)
+}
+
+func TestAsyncHandlerShutdown(t *testing.T) {
+	handler := &asyncSlowExample{closed: make(chan struct{})}
+	s, err := NewServer(handler, Address("127.0.0.1:12397"), NumLoops(1), AsyncHandler(1, 0, QueueBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12397")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Shutdown 等待 worker 中的消息处理完并写出回复
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	data, err := io.ReadAll(conn)
+	if err != nil || string(data) != "pong" {
+		t.Fatalf("reply should be pong, but %q %v", data, err)
+	}
+	select {
+	case <-handler.closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnClose not called")
+	}
+	if handler.overlap.Load() {
+		t.Fatal("OnClose should not run while OnMessage is running")
+	}
+}
+
+func TestAsyncHandlerStop(t *testing.T) {
+	handler := &asyncSlowExample{closed: make(chan struct{})}
+	s, err := NewServer(handler, Address("127.0.0.1:12400"), NumLoops(1), AsyncHandler(1, 0, QueueBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12400")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Stop 时消息还在 worker 中，OnClose 在 OnMessage 返回后、Stop 返回前回调
+	s.Stop()
+	select {
+	case <-handler.closed:
+	default:
+		t.Fatal("OnClose not called")
+	}
+	if handler.overlap.Load() {
+		t.Fatal("OnClose should not run while OnMessage is running")
+	}
+}
+
+func TestAsyncHandlerCloseAfterMessage(t *testing.T) {
+	handler := &asyncSlowExample{closed: make(chan struct{})}
+	s, err := NewServer(handler, Address("127.0.0.1:12398"), NumLoops(1), AsyncHandler(1, 0, QueueBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12398")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	_ = conn.Close()
+
+	select {
+	case <-handler.closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnClose not called")
+	}
+	if handler.overlap.Load() {
+		t.Fatal("OnClose should not run while OnMessage is running")
+	}
+}
@@ -0,0 +1,177 @@
+package goreaction
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// maxIovec 一次 writev 最多提交的段数（IOV_MAX）
+const maxIovec = 1024
+
+// pendingSend Send/SendV/SendFile 排队等待 flush 的数据，vec、file 为 nil 时 data 需经 Protocol.Packet 编码
+type pendingSend struct {
+	data interface{}
+	vec  [][]byte
+	file *fileSend
+	n    int64
+}
+
+// SendV 把 bufs 作为一条消息异步发送，不经过 Protocol.Packet，
+// 各段不拼接，由一次 writev 交给内核。返回后不能再修改 bufs
+func (c *Connection) SendV(bufs [][]byte) error {
+	if !c.connected.Load() {
+		return ErrConnectionClosed
+	}
+	if c.shutWr.Load() {
+		return ErrWriteShutdown
+	}
+	if c.aboveHighWater() {
+		return ErrWouldBlock
+	}
+
+	var n int64
+	for _, b := range bufs {
+		n += int64(len(b))
+	}
+	c.enqueue(pendingSend{vec: bufs, n: n})
+	return nil
+}
+
+// enqueue 加入待发送队列，同一轮 loop 中的多次发送合并为一次 flush
+func (c *Connection) enqueue(p pendingSend) {
+	c.sendQueued.Add(p.n)
+	c.pendMu.Lock()
+	c.pending = append(c.pending, p)
+	first := len(c.pending) == 1
+	c.pendMu.Unlock()
+
+	if first {
+		c.loop.QueueInLoop(c.flushPending)
+	}
+}
+
+// flushPending 在 loop 中编码并写出所有排队的数据
+func (c *Connection) flushPending() {
+	c.pendMu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.pendMu.Unlock()
+
+	var n int64
+	for _, p := range pending {
+		n += p.n
+	}
+	c.sendQueued.Add(-n)
+
+	bufs := make([][]byte, 0, len(pending))
+	for _, p := range pending {
+		switch {
+		case p.file != nil:
+			// 文件之前的数据先进入 outBuf，保证顺序
+			c.writeBufs(bufs)
+			bufs = bufs[:0]
+			if !c.connected.Load() {
+				c.fileDone(p.file, ErrConnectionClosed)
+				continue
+			}
+			c.queueFile(p.file)
+		case !c.connected.Load():
+		case p.vec != nil:
+			bufs = append(bufs, p.vec...)
+		default:
+			bufs = append(bufs, c.protocol.Packet(c, p.data))
+		}
+	}
+	if c.writeBufs(bufs) {
+		return
+	}
+	c.checkWriteBuffer()
+}
+
+// writeBufs 写出 bufs，TLS 连接逐段加密
+func (c *Connection) writeBufs(bufs [][]byte) (closed bool) {
+	if !c.connected.Load() {
+		return true
+	}
+	if len(bufs) == 0 {
+		return
+	}
+	if c.tls != nil {
+		for _, b := range bufs {
+			if c.writeTLS(b) {
+				return true
+			}
+		}
+		return
+	}
+	return c.sendvInLoop(bufs)
+}
+
+// sendvInLoop 与 sendInLoop 相同，多段数据用一次 writev 写出
+func (c *Connection) sendvInLoop(bufs [][]byte) (closed bool) {
+	if c.completion != nil {
+		return c.writeCompletion(bufs...)
+	}
+	if c.writePending() {
+		for _, b := range bufs {
+			c.outBuf.Write(b)
+		}
+		return
+	}
+
+	iov := bufs
+	if len(iov) > maxIovec {
+		iov = iov[:maxIovec]
+	}
+	n, err := unix.Writev(c.fd, iov)
+	if err != nil && err != unix.EAGAIN {
+		c.closeWith(CloseWriteError, err)
+		return true
+	}
+	if n > 0 {
+		c.touchWrite()
+	}
+	for _, b := range bufs {
+		if n >= len(b) {
+			n -= len(b)
+			continue
+		}
+		if n > 0 {
+			b = b[n:]
+			n = 0
+		}
+		c.outBuf.Write(b)
+	}
+	// 边沿触发时已注册写事件
+	if c.writePending() && !c.edgeTriggered {
+		if err := c.updateInterest(); err != nil {
+			c.reportError(OpWrite, err)
+			c.closeWith(CloseWriteError, err)
+			return true
+		}
+	}
+	return
+}
+
+// writeOutBuf 写出 outBuf 中最多 limit 字节，limit < 0 时不限制，数据回绕时用 writev 一次写出两段
+func (c *Connection) writeOutBuf(fd int, limit int) (n int, all bool, err error) {
+	ft, ed := c.outBuf.PeekAll()
+	if limit >= 0 {
+		if len(ft) >= limit {
+			ft, ed = ft[:limit], nil
+		} else if len(ft)+len(ed) > limit {
+			ed = ed[:limit-len(ft)]
+		}
+	}
+	if len(ed) == 0 {
+		n, err = unix.Write(fd, ft)
+	} else {
+		n, err = unix.Writev(fd, [][]byte{ft, ed})
+	}
+	if n > 0 {
+		c.touchWrite()
+	} else {
+		n = 0
+	}
+	c.outBuf.Retrieve(n)
+	return n, n == len(ft)+len(ed), err
+}
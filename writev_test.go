@@ -0,0 +1,70 @@
+package goreaction
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type sendVExample struct {
+	serverTest
+	conns chan *Connection
+}
+
+func (s *sendVExample) OnConnect(c *Connection) {
+	s.conns <- c
+}
+
+func TestConnSendV(t *testing.T) {
+	handler := &sendVExample{conns: make(chan *Connection, 1)}
+	s, err := NewServer(handler, Address("127.0.0.1:12382"), NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12382")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := <-handler.conns
+
+	// 阻塞 loop，使之后的发送在同一轮中合并
+	block := make(chan struct{})
+	c.loop.QueueInLoop(func() {
+		<-block
+	})
+	body := bytes.Repeat([]byte{'b'}, 1<<20)
+	if err := c.SendV([][]byte{[]byte("head:"), body}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send([]byte("tail")); err != nil {
+		t.Fatal(err)
+	}
+	c.pendMu.Lock()
+	pending := len(c.pending)
+	c.pendMu.Unlock()
+	if pending != 2 {
+		t.Fatalf("pending sends should be 2, but %d", pending)
+	}
+	close(block)
+
+	want := append(append([]byte("head:"), body...), "tail"...)
+	got := make([]byte, len(want))
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("data mismatch")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := c.WriteBufLen(); n != 0 {
+		t.Fatalf("write buffer should be empty, but %d", n)
+	}
+}